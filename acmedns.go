@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// serveACMEDNS runs an HTTP server implementing the joohoi/acme-dns API.
+// Clients register for a random subdomain of -domain, point their
+// _acme-challenge CNAME at it, and then update its TXT record with the
+// credentials they got, which are only good for that one subdomain.
+func serveACMEDNS(args []string) {
+	fs := flag.NewFlagSet("serve acme-dns", flag.ExitOnError)
+	listen := fs.String("listen", ":8053", "HTTP listen address")
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "Websupport zone the challenge records are created in")
+	domain := fs.String("domain", "", "domain registrations are created under (default: acme-dns.<zone>)")
+	storePath := fs.String("store", filepath.Join(stateDir(), "acme-dns.json"), "file the registrations are kept in")
+	registerFrom := fs.String("register-from", "127.0.0.0/8,::1/128", "comma-separated networks allowed to /register (empty: anyone)")
+	fs.Parse(args)
+
+	if *zoneName == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_TEST_ZONE must be set")
+	}
+	zone := dns.Fqdn(strings.ToLower(*zoneName))
+	if *domain == "" {
+		*domain = "acme-dns." + zone
+	}
+	base := dns.Fqdn(strings.ToLower(*domain))
+	if !dns.IsSubDomain(zone, base) {
+		log.Fatalf("Error: -domain %s is not within zone %s", base, zone)
+	}
+
+	registerNets, err := parseCIDRs(*registerFrom)
+	if err != nil {
+		log.Fatalf("Error: invalid -register-from: %v", err)
+	}
+	store, err := openACMEDNSStore(*storePath)
+	if err != nil {
+		log.Fatalf("Error: failed to open registration store: %v", err)
+	}
+
+	s := &acmeDNSServer{
+		provider:     newProviderFromEnv(""),
+		zone:         zone,
+		domain:       base,
+		store:        store,
+		registerFrom: registerNets,
+	}
+	log.Printf("🌐 Serving acme-dns API for %s on %s\n", strings.TrimSuffix(base, "."), *listen)
+	srv := &http.Server{Addr: *listen, Handler: s.routes(), ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(srv.ListenAndServe())
+}
+
+// acmeDNSRegistration is one set of acme-dns credentials. Only a hash of
+// the password is stored.
+type acmeDNSRegistration struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	Subdomain    string    `json:"subdomain"`
+	AllowFrom    []string  `json:"allowfrom,omitempty"`
+	TXT          []string  `json:"txt,omitempty"` // current values, oldest first
+	CreatedAt    time.Time `json:"created_at"`
+
+	// updating serializes the updates of the registration, which change
+	// its records through the API. TXT itself is guarded by the store's mu.
+	updating sync.Mutex
+}
+
+// acmeDNSStore keeps registrations in a JSON file.
+type acmeDNSStore struct {
+	path string
+
+	mu   sync.Mutex
+	regs map[string]*acmeDNSRegistration // by username
+}
+
+func openACMEDNSStore(path string) (*acmeDNSStore, error) {
+	s := &acmeDNSStore{path: path, regs: make(map[string]*acmeDNSRegistration)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var regs []*acmeDNSRegistration
+	if err := json.Unmarshal(data, &regs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for _, reg := range regs {
+		s.regs[reg.Username] = reg
+	}
+	return s, nil
+}
+
+// save writes the store. The caller must hold s.mu.
+func (s *acmeDNSStore) save() error {
+	regs := make([]*acmeDNSRegistration, 0, len(s.regs))
+	for _, reg := range s.regs {
+		regs = append(regs, reg)
+	}
+	data, err := json.MarshalIndent(regs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// acmeDNSServer implements the acme-dns HTTP API.
+type acmeDNSServer struct {
+	provider     *websupport.Provider
+	zone         string // fully qualified
+	domain       string // fully qualified, within zone
+	store        *acmeDNSStore
+	registerFrom []*net.IPNet
+}
+
+func (s *acmeDNSServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /register", s.register)
+	mux.HandleFunc("POST /update", s.update)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+	return mux
+}
+
+// register creates a new registration. The request body may restrict the
+// networks updates are accepted from, as {"allowfrom": ["192.0.2.0/24"]}.
+func (s *acmeDNSServer) register(w http.ResponseWriter, r *http.Request) {
+	if len(s.registerFrom) > 0 && !ipAllowed(r.RemoteAddr, s.registerFrom) {
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+
+	var req struct {
+		AllowFrom []string `json:"allowfrom"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeACMEDNSError(w, http.StatusBadRequest, "malformed_json_payload")
+			return
+		}
+	}
+	if req.AllowFrom == nil {
+		req.AllowFrom = []string{}
+	}
+	if _, err := parseCIDRs(strings.Join(req.AllowFrom, ",")); err != nil {
+		writeACMEDNSError(w, http.StatusBadRequest, "invalid_allowfrom_cidr")
+		return
+	}
+
+	password := randomToken(30)
+	sum := sha256.Sum256([]byte(password))
+	reg := &acmeDNSRegistration{
+		Username:     randomUUID(),
+		PasswordHash: hex.EncodeToString(sum[:]),
+		Subdomain:    randomUUID(),
+		AllowFrom:    req.AllowFrom,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	s.store.mu.Lock()
+	s.store.regs[reg.Username] = reg
+	err := s.store.save()
+	if err != nil {
+		delete(s.store.regs, reg.Username)
+	}
+	s.store.mu.Unlock()
+	if err != nil {
+		log.Printf("❌ Failed to save registration: %v\n", err)
+		writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+		return
+	}
+
+	log.Printf("✅ Registered %s from %s\n", reg.Subdomain, r.RemoteAddr)
+	writeACMEDNSJSON(w, http.StatusCreated, map[string]any{
+		"username":   reg.Username,
+		"password":   password,
+		"fulldomain": strings.TrimSuffix(reg.Subdomain+"."+s.domain, "."),
+		"subdomain":  reg.Subdomain,
+		"allowfrom":  reg.AllowFrom,
+	})
+}
+
+// acmeTXTPattern matches a DNS-01 challenge value: the unpadded base64url
+// encoding of a SHA-256 digest.
+var acmeTXTPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// update sets the TXT record of the caller's subdomain. Like acme-dns, the
+// two most recent values are kept, so a certificate for both a name and
+// its wildcard can be validated at once.
+func (s *acmeDNSServer) update(w http.ResponseWriter, r *http.Request) {
+	reg := s.authenticate(r)
+	if reg == nil {
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+
+	var req struct {
+		Subdomain string `json:"subdomain"`
+		TXT       string `json:"txt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeACMEDNSError(w, http.StatusBadRequest, "malformed_json_payload")
+		return
+	}
+	if req.Subdomain != reg.Subdomain {
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+	if !acmeTXTPattern.MatchString(req.TXT) {
+		writeACMEDNSError(w, http.StatusBadRequest, "bad_txt")
+		return
+	}
+
+	// The store is locked only to read and record the values, not across
+	// the API calls, so that updates of other registrations go ahead.
+	reg.updating.Lock()
+	defer reg.updating.Unlock()
+	s.store.mu.Lock()
+	values := slices.Clone(reg.TXT)
+	s.store.mu.Unlock()
+
+	name := libdns.RelativeName(reg.Subdomain+"."+s.domain, s.zone)
+	if len(values) >= 2 {
+		oldest := libdns.TXT{Name: name, Text: values[0]}
+		if _, err := s.provider.DeleteRecords(r.Context(), s.zone, []libdns.Record{oldest}); err != nil {
+			log.Printf("❌ Failed to delete TXT record of %s: %v\n", reg.Subdomain, err)
+			writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+			return
+		}
+		values = values[1:]
+		s.setTXT(reg, values)
+	}
+	rec := libdns.TXT{Name: name, Text: req.TXT}
+	if _, err := s.provider.AppendRecords(r.Context(), s.zone, []libdns.Record{rec}); err != nil {
+		log.Printf("❌ Failed to create TXT record of %s: %v\n", reg.Subdomain, err)
+		writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+		return
+	}
+	s.setTXT(reg, append(values, req.TXT))
+
+	log.Printf("✅ Updated TXT record of %s\n", reg.Subdomain)
+	writeACMEDNSJSON(w, http.StatusOK, map[string]string{"txt": req.TXT})
+}
+
+// setTXT records the current TXT values of reg and saves the store.
+func (s *acmeDNSServer) setTXT(reg *acmeDNSRegistration, values []string) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	reg.TXT = values
+	if err := s.store.save(); err != nil {
+		log.Printf("⚠️  Failed to save registration: %v\n", err)
+	}
+}
+
+// authenticate returns the registration of the X-Api-User and X-Api-Key
+// headers, or nil if they are invalid or the client is not in the
+// registration's allowfrom networks.
+func (s *acmeDNSServer) authenticate(r *http.Request) *acmeDNSRegistration {
+	s.store.mu.Lock()
+	reg := s.store.regs[r.Header.Get("X-Api-User")]
+	s.store.mu.Unlock()
+	if reg == nil {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(r.Header.Get("X-Api-Key")))
+	want, _ := hex.DecodeString(reg.PasswordHash)
+	if subtle.ConstantTimeCompare(sum[:], want) != 1 {
+		return nil
+	}
+	if len(reg.AllowFrom) > 0 {
+		nets, _ := parseCIDRs(strings.Join(reg.AllowFrom, ","))
+		if !ipAllowed(r.RemoteAddr, nets) {
+			return nil
+		}
+	}
+	return reg
+}
+
+func writeACMEDNSJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeACMEDNSError(w http.ResponseWriter, code int, msg string) {
+	writeACMEDNSJSON(w, code, map[string]string{"error": msg})
+}
+
+// parseCIDRs parses a comma-separated list of networks. Plain addresses
+// are taken as single-host networks.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			if ip := net.ParseIP(field); ip != nil && ip.To4() != nil {
+				field += "/32"
+			} else {
+				field += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ipAllowed reports whether the host of addr is in one of nets.
+func ipAllowed(addr string, nets []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	for _, n := range nets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomToken returns n random bytes, base64url encoded.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// randomUUID returns a random (version 4) UUID.
+func randomUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
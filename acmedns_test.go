@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+	"github.com/libdns/websupport/websupport"
+)
+
+// testACMEDNS returns an acme-dns server for acme-dns.example.com whose
+// records are kept by h, usually an in-memory API or a wrapper around one.
+func testACMEDNS(t *testing.T, h http.Handler) *acmeDNSServer {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	store, err := openACMEDNSStore(filepath.Join(t.TempDir(), "acme-dns.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &acmeDNSServer{
+		provider: &websupport.Provider{APIKey: "key", APISecret: "secret", ServiceID: "1", APIBase: srv.URL},
+		zone:     "example.com.",
+		domain:   "acme-dns.example.com.",
+		store:    store,
+	}
+}
+
+type acmeDNSCredentials struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Subdomain string `json:"subdomain"`
+}
+
+func (s *acmeDNSServer) testRegister(t *testing.T, body string) acmeDNSCredentials {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("POST", "/register", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	var creds acmeDNSCredentials
+	json.Unmarshal(w.Body.Bytes(), &creds)
+	return creds
+}
+
+// testUpdate sends an update with creds and returns the status code.
+func (s *acmeDNSServer) testUpdate(creds acmeDNSCredentials, subdomain, txt string) int {
+	body, _ := json.Marshal(map[string]string{"subdomain": subdomain, "txt": txt})
+	r := httptest.NewRequest("POST", "/update", strings.NewReader(string(body)))
+	r.Header.Set("X-Api-User", creds.Username)
+	r.Header.Set("X-Api-Key", creds.Password)
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, r)
+	return w.Code
+}
+
+// challengeValue returns a valid TXT value made of c.
+func challengeValue(c string) string {
+	return strings.Repeat(c, 43)
+}
+
+func TestACMEDNSUpdateAuth(t *testing.T) {
+	api := fakeapi.New()
+	s := testACMEDNS(t, api)
+	creds := s.testRegister(t, "")
+	other := s.testRegister(t, "")
+	limited := s.testRegister(t, `{"allowfrom": ["10.0.0.0/8"]}`)
+
+	wrongKey := creds
+	wrongKey.Password = other.Password
+	for _, tt := range []struct {
+		name      string
+		creds     acmeDNSCredentials
+		subdomain string
+		txt       string
+		want      int
+	}{
+		{"wrong key", wrongKey, creds.Subdomain, challengeValue("a"), http.StatusUnauthorized},
+		{"unknown user", acmeDNSCredentials{Username: "nobody", Password: creds.Password}, creds.Subdomain, challengeValue("a"), http.StatusUnauthorized},
+		{"other subdomain", other, creds.Subdomain, challengeValue("a"), http.StatusUnauthorized},
+		{"outside allowfrom", limited, limited.Subdomain, challengeValue("a"), http.StatusUnauthorized},
+		{"bad txt", creds, creds.Subdomain, "not a challenge", http.StatusBadRequest},
+	} {
+		if code := s.testUpdate(tt.creds, tt.subdomain, tt.txt); code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if reqs := api.Requests(); len(reqs) != 0 {
+		t.Errorf("refused updates sent %v", reqs)
+	}
+}
+
+func TestACMEDNSUpdateKeepsTwoValues(t *testing.T) {
+	api := fakeapi.New()
+	s := testACMEDNS(t, api)
+	creds := s.testRegister(t, "")
+
+	for _, c := range []string{"a", "b", "c"} {
+		if code := s.testUpdate(creds, creds.Subdomain, challengeValue(c)); code != http.StatusOK {
+			t.Fatalf("update %s: status %d", c, code)
+		}
+	}
+	var values []string
+	for _, rec := range api.Records() {
+		if rec.Name != creds.Subdomain+".acme-dns" || rec.Type != "TXT" {
+			t.Errorf("unexpected record %+v", rec)
+		}
+		values = append(values, rec.Content)
+	}
+	slices.Sort(values)
+	if want := []string{challengeValue("b"), challengeValue("c")}; !slices.Equal(values, want) {
+		t.Errorf("values %v, want %v", values, want)
+	}
+}
+
+func TestACMEDNSUpdatesDontBlockOtherRegistrations(t *testing.T) {
+	api := fakeapi.New()
+	release := make(chan struct{})
+	s := testACMEDNS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Creating the first registration's record hangs until released.
+		if r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/dns/record") {
+			var rec fakeapi.Record
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &rec)
+			if rec.Content == challengeValue("a") {
+				<-release
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		api.ServeHTTP(w, r)
+	}))
+	slow, fast := s.testRegister(t, ""), s.testRegister(t, "")
+
+	slowDone, fastDone := make(chan int), make(chan int)
+	go func() { slowDone <- s.testUpdate(slow, slow.Subdomain, challengeValue("a")) }()
+	time.Sleep(50 * time.Millisecond)
+	go func() { fastDone <- s.testUpdate(fast, fast.Subdomain, challengeValue("b")) }()
+	select {
+	case code := <-fastDone:
+		if code != http.StatusOK {
+			t.Errorf("update of another registration: status %d", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("update of another registration waited for the blocked one")
+	}
+	close(release)
+	if code := <-slowDone; code != http.StatusOK {
+		t.Errorf("blocked update: status %d", code)
+	}
+}
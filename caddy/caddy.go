@@ -0,0 +1,179 @@
+// Package caddy registers the Websupport DNS provider as the Caddy module
+// dns.providers.websupport. Build Caddy with it using:
+//
+//	xcaddy build --with github.com/libdns/websupport/caddy
+package caddy
+
+import (
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// Provider lets Caddy read and manipulate DNS records hosted by Websupport.
+type Provider struct {
+	*websupport.Provider
+
+	// APIKeyFile and APISecretFile name files holding the credentials,
+	// read instead of api_key and api_secret. They are re-read when the
+	// API rejects the credentials, so secrets can be rotated without
+	// reloading Caddy.
+	APIKeyFile    string `json:"api_key_file,omitempty"`
+	APISecretFile string `json:"api_secret_file,omitempty"`
+
+	// CredentialsCommand is run to print the API key and secret on two
+	// lines, and again when the API rejects them.
+	CredentialsCommand []string `json:"credentials_command,omitempty"`
+}
+
+func init() {
+	caddy.RegisterModule(Provider{})
+}
+
+// CaddyModule returns the Caddy module information.
+func (Provider) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dns.providers.websupport",
+		New: func() caddy.Module { return &Provider{Provider: new(websupport.Provider)} },
+	}
+}
+
+// Provision expands placeholders such as {env.WEBSUPPORT_API_KEY} in the
+// configuration and adds the provider's metrics to Caddy's, so they are
+// served by its metrics endpoint.
+func (p *Provider) Provision(ctx caddy.Context) error {
+	repl := caddy.NewReplacer()
+	p.Provider.APIKey = repl.ReplaceAll(p.Provider.APIKey, "")
+	p.Provider.APISecret = repl.ReplaceAll(p.Provider.APISecret, "")
+	p.Provider.ServiceID = repl.ReplaceAll(p.Provider.ServiceID, "")
+	p.Provider.APIBase = repl.ReplaceAll(p.Provider.APIBase, "")
+	switch {
+	case len(p.CredentialsCommand) > 0:
+		p.Provider.CredentialSource = websupport.CommandCredentials{Command: p.CredentialsCommand[0], Args: p.CredentialsCommand[1:]}
+	case p.APIKeyFile != "" || p.APISecretFile != "":
+		p.Provider.CredentialSource = websupport.FileCredentials{
+			KeyFile:    repl.ReplaceAll(p.APIKeyFile, ""),
+			SecretFile: repl.ReplaceAll(p.APISecretFile, ""),
+		}
+	}
+
+	metrics, err := provisionMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	p.Provider.Metrics = metrics
+	return nil
+}
+
+// provisionMetrics returns the metrics shared by all providers, registered
+// with Caddy's metrics registry by the first provider to provision.
+func provisionMetrics(ctx caddy.Context) (*websupport.Metrics, error) {
+	metrics := websupport.NewMetrics()
+	if reg := ctx.GetMetricsRegistry(); reg != nil {
+		if err := reg.Register(metrics); err != nil {
+			already, ok := err.(prometheus.AlreadyRegisteredError)
+			if !ok {
+				return nil, err
+			}
+			metrics = already.ExistingCollector.(*websupport.Metrics)
+		}
+	}
+	return metrics, nil
+}
+
+// UnmarshalCaddyfile sets up the DNS provider from Caddyfile tokens. Syntax:
+//
+//	websupport {
+//	    api_key <key>
+//	    api_secret <secret>
+//	    api_key_file <path>
+//	    api_secret_file <path>
+//	    credentials_command <command> [<args...>]
+//	    rate_limit <requests per second> [<burst>]
+//	    service_id <id>
+//	    api_base <url>
+//	}
+//
+// The credentials are given either as api_key and api_secret, as files, or
+// as a command printing them.
+func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			var target *string
+			switch d.Val() {
+			case "credentials_command":
+				if p.CredentialsCommand != nil {
+					return d.Err("credentials_command already set")
+				}
+				p.CredentialsCommand = d.RemainingArgs()
+				if len(p.CredentialsCommand) == 0 {
+					return d.ArgErr()
+				}
+				continue
+			case "rate_limit":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				rl := &websupport.RateLimit{}
+				var err error
+				if rl.RequestsPerSecond, err = strconv.ParseFloat(args[0], 64); err != nil {
+					return d.Errf("invalid rate_limit: %v", err)
+				}
+				if len(args) == 2 {
+					if rl.Burst, err = strconv.Atoi(args[1]); err != nil {
+						return d.Errf("invalid rate_limit burst: %v", err)
+					}
+				}
+				p.Provider.RateLimit = rl
+				continue
+			case "api_key":
+				target = &p.Provider.APIKey
+			case "api_secret":
+				target = &p.Provider.APISecret
+			case "service_id":
+				target = &p.Provider.ServiceID
+			case "api_key_file":
+				target = &p.APIKeyFile
+			case "api_secret_file":
+				target = &p.APISecretFile
+			case "api_base":
+				target = &p.Provider.APIBase
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+			if *target != "" {
+				return d.Errf("%s already set", d.Val())
+			}
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			*target = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		}
+	}
+	hasKeys := p.Provider.APIKey != "" && p.Provider.APISecret != ""
+	hasFiles := p.APIKeyFile != "" && p.APISecretFile != ""
+	if !hasKeys && !hasFiles && len(p.CredentialsCommand) == 0 {
+		return d.Err("missing api_key and api_secret, api_key_file and api_secret_file, or credentials_command")
+	}
+	if p.Provider.ServiceID == "" {
+		return d.Err("missing service_id")
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddyfile.Unmarshaler = (*Provider)(nil)
+	_ caddy.Provisioner     = (*Provider)(nil)
+)
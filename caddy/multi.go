@@ -0,0 +1,118 @@
+package caddy
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// MultiProvider lets Caddy manage the zones of several Websupport accounts
+// with one configuration; see websupport.MultiProvider.
+type MultiProvider struct{ *websupport.MultiProvider }
+
+func init() {
+	caddy.RegisterModule(MultiProvider{})
+}
+
+// CaddyModule returns the Caddy module information.
+func (MultiProvider) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dns.providers.websupport_multi",
+		New: func() caddy.Module { return &MultiProvider{new(websupport.MultiProvider)} },
+	}
+}
+
+// Provision expands placeholders in the account credentials and shares
+// the metrics of the single-account providers.
+func (m *MultiProvider) Provision(ctx caddy.Context) error {
+	repl := caddy.NewReplacer()
+	m.MultiProvider.APIBase = repl.ReplaceAll(m.MultiProvider.APIBase, "")
+	for i := range m.Accounts {
+		acct := &m.Accounts[i]
+		acct.APIKey = repl.ReplaceAll(acct.APIKey, "")
+		acct.APISecret = repl.ReplaceAll(acct.APISecret, "")
+	}
+	metrics, err := provisionMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	m.Configure = func(p *websupport.Provider) { p.Metrics = metrics }
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the DNS provider from Caddyfile tokens. Syntax:
+//
+//	websupport_multi {
+//	    account {
+//	        api_key <key>
+//	        api_secret <secret>
+//	        zones <glob...>
+//	        service_id <zone> <id>
+//	    }
+//	    account { ... }
+//	    api_base <url>
+//	}
+//
+// Accounts are tried in order; zones and service_id are optional.
+func (m *MultiProvider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "api_base":
+				if !d.AllArgs(&m.MultiProvider.APIBase) {
+					return d.ArgErr()
+				}
+			case "account":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				var acct websupport.Account
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "api_key":
+						if !d.AllArgs(&acct.APIKey) {
+							return d.ArgErr()
+						}
+					case "api_secret":
+						if !d.AllArgs(&acct.APISecret) {
+							return d.ArgErr()
+						}
+					case "zones":
+						acct.Zones = append(acct.Zones, d.RemainingArgs()...)
+					case "service_id":
+						var zone, id string
+						if !d.AllArgs(&zone, &id) {
+							return d.ArgErr()
+						}
+						if acct.ServiceIDs == nil {
+							acct.ServiceIDs = make(map[string]string)
+						}
+						acct.ServiceIDs[zone] = id
+					default:
+						return d.Errf("unrecognized account subdirective '%s'", d.Val())
+					}
+				}
+				if acct.APIKey == "" || acct.APISecret == "" {
+					return d.Err("account is missing api_key or api_secret")
+				}
+				m.Accounts = append(m.Accounts, acct)
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+	if len(m.Accounts) == 0 {
+		return d.Err("no account configured")
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddyfile.Unmarshaler = (*MultiProvider)(nil)
+	_ caddy.Provisioner     = (*MultiProvider)(nil)
+)
@@ -0,0 +1,111 @@
+// Command cert-manager-webhook is a cert-manager ACME DNS-01 webhook solver
+// for Websupport DNS.
+//
+// It serves cert-manager's webhook solver API (acme.cert-manager.io
+// v1alpha1 ChallengePayload) behind the Kubernetes API aggregation layer,
+// and creates and deletes the challenge TXT records through the Websupport
+// provider. Credentials are read from the Secrets referenced in the Issuer's
+// solver config:
+//
+//	webhook:
+//	  groupName: acme.example.com
+//	  solverName: websupport
+//	  config:
+//	    serviceID: "1234567"
+//	    apiKeySecretRef: {name: websupport-credentials, key: api-key}
+//	    apiSecretSecretRef: {name: websupport-credentials, key: api-secret}
+//
+// With TLS, clients of the solver API (/apis/...) must present a
+// certificate signed by -client-ca-file, the CA the Kubernetes API server's
+// aggregation layer authenticates with (its requestheader client CA), so
+// that only the API server can ask for Secrets to be read. The health
+// endpoints stay open to the kubelet's probes, which present none. For local testing without a cluster, pass
+// -secrets-dir and no TLS files; -api-base can point at a stand-in for the
+// Websupport API.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+func main() {
+	groupName := flag.String("group-name", os.Getenv("GROUP_NAME"), "API group the solver is registered under (GROUP_NAME)")
+	port := flag.Int("secure-port", 443, "port to serve on")
+	certFile := flag.String("tls-cert-file", "", "TLS certificate; serves plain HTTP if empty")
+	keyFile := flag.String("tls-private-key-file", "", "TLS private key")
+	clientCAFile := flag.String("client-ca-file", "", "CA for verifying client certificates of the Kubernetes API server (required with TLS)")
+	secretsDir := flag.String("secrets-dir", "", "read Secrets from <dir>/<namespace>/<name>/<key> instead of the Kubernetes API")
+	apiBase := flag.String("api-base", websupport.DefaultAPIBase, "Websupport API base URL")
+	flag.Parse()
+
+	if *groupName == "" {
+		log.Fatal("Error: -group-name or GROUP_NAME must be set")
+	}
+	if *certFile != "" && *clientCAFile == "" {
+		log.Fatal("Error: -client-ca-file is required with -tls-cert-file, so that only the Kubernetes API server can call the solver")
+	}
+	if *certFile == "" && *secretsDir == "" {
+		log.Fatal("Error: plain HTTP is for local testing only and requires -secrets-dir; pass -tls-cert-file and -client-ca-file")
+	}
+
+	var secrets secretReader
+	if *secretsDir != "" {
+		secrets = dirSecrets(*secretsDir)
+	} else {
+		kube, err := newKubeSecrets()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		secrets = kube
+	}
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", *port),
+		Handler:           &solver{groupName: *groupName, secrets: secrets, apiBase: *apiBase},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("Serving %s/v1alpha1 solver %q on %s", *groupName, solverName, srv.Addr)
+	if *certFile == "" {
+		log.Fatal(srv.ListenAndServe())
+	}
+
+	caPEM, err := os.ReadFile(*clientCAFile)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		log.Fatalf("Error: no certificates in %s", *clientCAFile)
+	}
+	srv.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.Handler = requireClientCert(srv.Handler)
+	log.Fatal(srv.ListenAndServeTLS(*certFile, *keyFile))
+}
+
+// requireClientCert refuses requests other than health checks that did not
+// come with a client certificate verified against the server's ClientCAs.
+// The TLS handshake only verifies certificates that are given, so that
+// probes without one can reach /healthz, /livez and /readyz.
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/livez", "/readyz":
+		default:
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				http.Error(w, "client certificate required", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
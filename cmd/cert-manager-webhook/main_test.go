@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newClientCert returns a certificate for client authentication signed by
+// a new CA, and a pool holding the CA.
+func newClientCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestRequireClientCert(t *testing.T) {
+	s, _ := testSolver(t)
+	cert, pool := newClientCert(t)
+	srv := httptest.NewUnstartedServer(requireClientCert(s))
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(client *http.Client, path string) int {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	probe := srv.Client()
+	apiServer := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      probe.Transport.(*http.Transport).TLSClientConfig.RootCAs,
+		Certificates: []tls.Certificate{cert},
+	}}}
+
+	for _, path := range []string{"/healthz", "/livez", "/readyz"} {
+		if code := get(probe, path); code != http.StatusOK {
+			t.Errorf("%s without a client certificate: status %d, want 200", path, code)
+		}
+	}
+	for _, path := range []string{"/apis", "/apis/" + testGroup + "/v1alpha1"} {
+		if code := get(probe, path); code != http.StatusUnauthorized {
+			t.Errorf("%s without a client certificate: status %d, want 401", path, code)
+		}
+		if code := get(apiServer, path); code != http.StatusOK {
+			t.Errorf("%s with a client certificate: status %d, want 200", path, code)
+		}
+	}
+
+	// A certificate from another CA fails the handshake.
+	other, _ := newClientCert(t)
+	stranger := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      probe.Transport.(*http.Transport).TLSClientConfig.RootCAs,
+		Certificates: []tls.Certificate{other},
+	}}}
+	if resp, err := stranger.Get(srv.URL + "/apis"); err == nil {
+		resp.Body.Close()
+		t.Errorf("request with an unknown client certificate: status %d, want a handshake failure", resp.StatusCode)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretReader returns the value of a key in a Kubernetes Secret.
+type secretReader interface {
+	SecretKey(ctx context.Context, namespace, name, key string) (string, error)
+}
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeSecrets reads Secrets from the Kubernetes API using the pod's service
+// account. The webhook's RBAC role must allow "get" on the Secrets.
+type kubeSecrets struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+func newKubeSecrets() (*kubeSecrets, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST is not set); use -secrets-dir")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates in %s", filepath.Join(serviceAccountDir, "ca.crt"))
+	}
+	return &kubeSecrets{
+		host:  "https://" + net.JoinHostPort(host, port),
+		token: strings.TrimSpace(string(token)),
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+func (k *kubeSecrets) SecretKey(ctx context.Context, namespace, name, key string) (string, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", k.host, url.PathEscape(namespace), url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to get secret %s/%s: %s, body: %s", namespace, name, resp.Status, string(body))
+	}
+
+	// Secret data is base64-encoded, which encoding/json decodes into
+	// []byte for us.
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode secret %s/%s: %v", namespace, name, err)
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %q", namespace, name, key)
+	}
+	return string(value), nil
+}
+
+// dirSecrets reads Secrets from files laid out as <dir>/<namespace>/<name>/<key>,
+// for running the webhook locally without a cluster.
+type dirSecrets string
+
+func (d dirSecrets) SecretKey(ctx context.Context, namespace, name, key string) (string, error) {
+	for _, part := range []string{namespace, name, key} {
+		if part == "" || strings.ContainsAny(part, `/\`) || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid secret reference %s/%s key %q", namespace, name, key)
+		}
+	}
+	value, err := os.ReadFile(filepath.Join(string(d), namespace, name, key))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
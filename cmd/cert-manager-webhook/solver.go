@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// solverName is the name the solver is referenced by in an Issuer's
+// webhook configuration.
+const solverName = "websupport"
+
+// solverConfig is the per-Issuer solver configuration (the "config" field
+// of the webhook solver).
+type solverConfig struct {
+	APIKeySecretRef    secretKeySelector `json:"apiKeySecretRef"`
+	APISecretSecretRef secretKeySelector `json:"apiSecretSecretRef"`
+	ServiceID          string            `json:"serviceID"`
+	TTL                int               `json:"ttl,omitempty"`
+}
+
+// secretKeySelector references a key of a Secret in the challenge's
+// resource namespace.
+type secretKeySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// challengePayload mirrors cert-manager's acme.cert-manager.io/v1alpha1
+// ChallengePayload, which is POSTed to the solver for each challenge.
+type challengePayload struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *challengeRequest  `json:"request,omitempty"`
+	Response   *challengeResponse `json:"response,omitempty"`
+}
+
+type challengeRequest struct {
+	UID                     string          `json:"uid"`
+	Action                  string          `json:"action"` // "Present" or "CleanUp"
+	Type                    string          `json:"type"`
+	DNSName                 string          `json:"dnsName"`
+	Key                     string          `json:"key"`
+	ResourceNamespace       string          `json:"resourceNamespace"`
+	ResolvedFQDN            string          `json:"resolvedFQDN"`
+	ResolvedZone            string          `json:"resolvedZone"`
+	AllowAmbientCredentials bool            `json:"allowAmbientCredentials"`
+	Config                  json.RawMessage `json:"config,omitempty"`
+}
+
+type challengeResponse struct {
+	UID     string  `json:"uid"`
+	Success bool    `json:"success"`
+	Result  *status `json:"status,omitempty"`
+}
+
+// status mirrors the parts of a Kubernetes metav1.Status that cert-manager
+// reports back on failure.
+type status struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Reason     string `json:"reason,omitempty"`
+	Code       int    `json:"code"`
+}
+
+// solver serves cert-manager's webhook solver API for one API group.
+type solver struct {
+	groupName string
+	secrets   secretReader
+	apiBase   string // Websupport API base URL; never taken from a request
+}
+
+// ServeHTTP implements the discovery endpoints the Kubernetes API
+// aggregation layer needs and the solver endpoint itself.
+func (s *solver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	version := "/apis/" + s.groupName + "/v1alpha1"
+	switch {
+	case r.URL.Path == "/healthz" || r.URL.Path == "/livez" || r.URL.Path == "/readyz":
+		w.Write([]byte("ok"))
+	case r.URL.Path == "/apis":
+		writeJSON(w, http.StatusOK, map[string]any{
+			"kind":       "APIGroupList",
+			"apiVersion": "v1",
+			"groups":     []any{s.apiGroup()},
+		})
+	case r.URL.Path == "/apis/"+s.groupName:
+		writeJSON(w, http.StatusOK, s.apiGroup())
+	case r.URL.Path == version:
+		writeJSON(w, http.StatusOK, map[string]any{
+			"kind":         "APIResourceList",
+			"apiVersion":   "v1",
+			"groupVersion": s.groupName + "/v1alpha1",
+			"resources": []any{map[string]any{
+				"name":         solverName,
+				"singularName": solverName,
+				"namespaced":   false,
+				"kind":         "ChallengePayload",
+				"verbs":        []string{"create"},
+			}},
+		})
+	case r.URL.Path == version+"/"+solverName && r.Method == http.MethodPost:
+		s.serveChallenge(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *solver) apiGroup() map[string]any {
+	gv := map[string]string{"groupVersion": s.groupName + "/v1alpha1", "version": "v1alpha1"}
+	return map[string]any{
+		"kind":             "APIGroup",
+		"apiVersion":       "v1",
+		"name":             s.groupName,
+		"versions":         []any{gv},
+		"preferredVersion": gv,
+	}
+}
+
+// serveChallenge decodes a ChallengePayload, runs the requested action and
+// answers with the payload's response filled in.
+func (s *solver) serveChallenge(w http.ResponseWriter, r *http.Request) {
+	var payload challengePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Request == nil {
+		writeJSON(w, http.StatusBadRequest, &status{
+			Kind: "Status", APIVersion: "v1", Status: "Failure",
+			Message: "invalid ChallengePayload", Reason: "BadRequest", Code: http.StatusBadRequest,
+		})
+		return
+	}
+
+	req := payload.Request
+	resp := &challengeResponse{UID: req.UID, Success: true}
+	var err error
+	switch req.Action {
+	case "Present":
+		err = s.present(r.Context(), req)
+	case "CleanUp":
+		err = s.cleanUp(r.Context(), req)
+	default:
+		err = fmt.Errorf("unsupported action %q", req.Action)
+	}
+	if err != nil {
+		log.Printf("%s %s: %v", req.Action, req.ResolvedFQDN, err)
+		resp.Success = false
+		resp.Result = &status{
+			Kind: "Status", APIVersion: "v1", Status: "Failure",
+			Message: err.Error(), Code: http.StatusInternalServerError,
+		}
+	} else {
+		log.Printf("%s %s: ok", req.Action, req.ResolvedFQDN)
+	}
+
+	payload.Request = nil
+	payload.Response = resp
+	writeJSON(w, http.StatusOK, payload)
+}
+
+// present creates the challenge TXT record.
+func (s *solver) present(ctx context.Context, req *challengeRequest) error {
+	provider, err := s.provider(ctx, req)
+	if err != nil {
+		return err
+	}
+	cfg, _ := decodeConfig(req.Config)
+	rec := &libdns.TXT{
+		Name: libdns.RelativeName(req.ResolvedFQDN, req.ResolvedZone),
+		Text: req.Key,
+		TTL:  time.Duration(cfg.TTL) * time.Second,
+	}
+	_, err = provider.AppendRecords(ctx, req.ResolvedZone, []libdns.Record{rec})
+	return err
+}
+
+// cleanUp deletes the challenge TXT record. cert-manager may call CleanUp
+// for records that were never presented, which is not an error.
+func (s *solver) cleanUp(ctx context.Context, req *challengeRequest) error {
+	provider, err := s.provider(ctx, req)
+	if err != nil {
+		return err
+	}
+	rec := &libdns.TXT{
+		Name: libdns.RelativeName(req.ResolvedFQDN, req.ResolvedZone),
+		Text: req.Key,
+	}
+	_, err = provider.DeleteRecords(ctx, req.ResolvedZone, []libdns.Record{rec})
+	return err
+}
+
+// provider builds a provider limited to ACME challenge records, with the
+// credentials from the Secrets the solver config refers to.
+func (s *solver) provider(ctx context.Context, req *challengeRequest) (*websupport.ACMEOnlyProvider, error) {
+	cfg, err := decodeConfig(req.Config)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ServiceID == "" {
+		return nil, fmt.Errorf("serviceID is required in the solver config")
+	}
+	apiKey, err := s.secrets.SecretKey(ctx, req.ResourceNamespace, cfg.APIKeySecretRef.Name, cfg.APIKeySecretRef.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apiKeySecretRef: %v", err)
+	}
+	apiSecret, err := s.secrets.SecretKey(ctx, req.ResourceNamespace, cfg.APISecretSecretRef.Name, cfg.APISecretSecretRef.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apiSecretSecretRef: %v", err)
+	}
+	return websupport.ACMEOnly(&websupport.Provider{
+		APIKey:    strings.TrimSpace(apiKey),
+		APISecret: strings.TrimSpace(apiSecret),
+		APIBase:   s.apiBase,
+		ServiceID: cfg.ServiceID,
+	}), nil
+}
+
+func decodeConfig(raw json.RawMessage) (solverConfig, error) {
+	var cfg solverConfig
+	if len(raw) == 0 {
+		return cfg, fmt.Errorf("solver config is missing")
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to decode solver config: %v", err)
+	}
+	return cfg, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
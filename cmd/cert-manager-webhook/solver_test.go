@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+const testGroup = "acme.example.com"
+
+// testSolver returns a solver reading Secrets from a temporary directory
+// and talking to an in-memory Websupport API.
+func testSolver(t *testing.T) (*solver, *fakeapi.API) {
+	t.Helper()
+	api := fakeapi.New(fakeapi.Record{Name: "@", Type: "NS", Content: "ns1.websupport.sk", TTL: 600})
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "cert-manager", "websupport-credentials")
+	if err := os.MkdirAll(secret, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"api-key": "test-key\n", "api-secret": "test-secret\n"} {
+		if err := os.WriteFile(filepath.Join(secret, key), []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &solver{groupName: testGroup, secrets: dirSecrets(dir), apiBase: srv.URL}, api
+}
+
+// challenge POSTs a ChallengePayload for action to s and returns the
+// response.
+func challenge(t *testing.T, s *solver, action, namespace string) *challengeResponse {
+	t.Helper()
+	payload := challengePayload{
+		APIVersion: "acme.cert-manager.io/v1alpha1",
+		Kind:       "ChallengePayload",
+		Request: &challengeRequest{
+			UID:               "uid-" + action,
+			Action:            action,
+			Type:              "dns-01",
+			DNSName:           "www.example.com",
+			Key:               "challenge-token",
+			ResourceNamespace: namespace,
+			ResolvedFQDN:      "_acme-challenge.www.example.com.",
+			ResolvedZone:      "example.com.",
+			// apiBase is not a solver config field; it must be ignored.
+			Config: json.RawMessage(`{"serviceID": "1234567", "apiBase": "http://attacker.invalid",
+				"apiKeySecretRef": {"name": "websupport-credentials", "key": "api-key"},
+				"apiSecretSecretRef": {"name": "websupport-credentials", "key": "api-secret"}}`),
+		},
+	}
+	body, _ := json.Marshal(payload)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/apis/"+testGroup+"/v1alpha1/"+solverName, bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status %d: %s", action, rec.Code, rec.Body)
+	}
+	var resp challengePayload
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("%s: %v", action, err)
+	}
+	if resp.Response == nil || resp.Response.UID != payload.Request.UID {
+		t.Fatalf("%s: response %+v does not answer %s", action, resp.Response, payload.Request.UID)
+	}
+	return resp.Response
+}
+
+func TestSolverPresentAndCleanUp(t *testing.T) {
+	s, api := testSolver(t)
+
+	if resp := challenge(t, s, "Present", "cert-manager"); !resp.Success {
+		t.Fatalf("Present failed: %+v", resp.Result)
+	}
+	reqs := api.Requests()
+	if len(reqs) != 1 || reqs[0].Method != http.MethodPost || reqs[0].Path != "/service/1234567/dns/record" {
+		t.Fatalf("Present: requests %+v, want one POST /service/1234567/dns/record", reqs)
+	}
+	want := fakeapi.Record{Name: "_acme-challenge.www", Type: "TXT", Content: "challenge-token", TTL: 120}
+	if got := reqs[0].Record; got != want {
+		t.Errorf("Present: created %+v, want %+v", got, want)
+	}
+	if reqs[0].APIKey != "test-key" {
+		t.Errorf("Present: API key %q, want the one from the Secret", reqs[0].APIKey)
+	}
+
+	if resp := challenge(t, s, "CleanUp", "cert-manager"); !resp.Success {
+		t.Fatalf("CleanUp failed: %+v", resp.Result)
+	}
+	reqs = api.Requests()
+	if len(reqs) != 2 || reqs[1].Method != http.MethodDelete || !strings.HasPrefix(reqs[1].Path, "/service/1234567/dns/record/") {
+		t.Fatalf("CleanUp: requests %+v, want a DELETE of the record", reqs)
+	}
+	for _, rec := range api.Records() {
+		if rec.Type == "TXT" {
+			t.Errorf("CleanUp: %+v left in the zone", rec)
+		}
+	}
+
+	// A second CleanUp finds nothing to delete, which is not an error.
+	if resp := challenge(t, s, "CleanUp", "cert-manager"); !resp.Success {
+		t.Fatalf("repeated CleanUp failed: %+v", resp.Result)
+	}
+	if reqs := api.Requests(); len(reqs) != 2 {
+		t.Errorf("repeated CleanUp: requests %+v, want no more changes", reqs[2:])
+	}
+}
+
+func TestSolverMissingSecret(t *testing.T) {
+	s, api := testSolver(t)
+
+	resp := challenge(t, s, "Present", "other-namespace")
+	if resp.Success || resp.Result == nil || !strings.Contains(resp.Result.Message, "apiKeySecretRef") {
+		t.Fatalf("Present with a missing Secret: %+v, want a failure naming apiKeySecretRef", resp)
+	}
+	if reqs := api.Requests(); len(reqs) != 0 {
+		t.Errorf("requests %+v, want none", reqs)
+	}
+}
@@ -0,0 +1,63 @@
+// Command external-dns-webhook is an ExternalDNS webhook provider for
+// Websupport DNS.
+//
+// ExternalDNS runs it as a sidecar and talks to it over the webhook
+// provider protocol (--provider=webhook). Records are managed through the
+// Websupport provider, including the TXT records of ExternalDNS' ownership
+// registry. Credentials and the zone are read from WEBSUPPORT_API_KEY,
+// WEBSUPPORT_API_SECRET, WEBSUPPORT_SERVICE_ID and WEBSUPPORT_ZONE; the key
+// and secret may be given as mounted secret files with
+// WEBSUPPORT_API_KEY_FILE and WEBSUPPORT_API_SECRET_FILE instead, which are
+// re-read when the API rejects the old ones after a rotation.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8888", "address of the webhook API (ExternalDNS' --webhook-provider-url)")
+	healthListen := flag.String("health-listen", ":8080", "address of the /healthz and /metrics endpoints")
+	zone := flag.String("zone", os.Getenv("WEBSUPPORT_ZONE"), "zone managed through the webhook (WEBSUPPORT_ZONE)")
+	flag.Parse()
+
+	provider := &websupport.Provider{
+		APIBase:          os.Getenv("WEBSUPPORT_API_BASE"),
+		ServiceID:        os.Getenv("WEBSUPPORT_SERVICE_ID"),
+		CredentialSource: websupport.EnvCredentials{},
+		Metrics:          websupport.NewMetrics(),
+	}
+	if _, err := provider.CredentialSource.Credentials(context.Background()); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if provider.ServiceID == "" {
+		log.Fatal("Error: WEBSUPPORT_SERVICE_ID environment variable must be set")
+	}
+	if *zone == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_ZONE must be set")
+	}
+
+	h := &webhook{provider: provider, zone: dns.Fqdn(*zone)}
+
+	go func() {
+		health := http.NewServeMux()
+		health.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+		health.Handle("GET /metrics", provider.Metrics.Handler())
+		log.Fatal((&http.Server{Addr: *healthListen, Handler: health, ReadHeaderTimeout: 10 * time.Second}).ListenAndServe())
+	}()
+
+	log.Printf("Serving ExternalDNS webhook for %s on %s", *zone, *listen)
+	srv := &http.Server{Addr: *listen, Handler: h.routes(), ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(srv.ListenAndServe())
+}
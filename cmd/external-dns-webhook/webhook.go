@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// mediaType is the media type of the ExternalDNS webhook provider protocol.
+const mediaType = "application/external.dns.webhook+json;version=1"
+
+// managedTypes are the record types exchanged with ExternalDNS.
+var managedTypes = map[string]bool{
+	"A": true, "AAAA": true, "CNAME": true, "TXT": true, "MX": true, "SRV": true, "NS": true,
+}
+
+// managed reports whether records of name and type, relative to the zone,
+// are exchanged with ExternalDNS. NS records delegate subdomains; the
+// zone's own NS records belong to Websupport and are left out, so that a
+// plan can never remove the delegation of the zone.
+func managed(name, typ string) bool {
+	return managedTypes[typ] && !(typ == "NS" && (name == "@" || name == ""))
+}
+
+// endpoint mirrors ExternalDNS' endpoint.Endpoint.
+type endpoint struct {
+	DNSName          string            `json:"dnsName"`
+	Targets          []string          `json:"targets"`
+	RecordType       string            `json:"recordType"`
+	SetIdentifier    string            `json:"setIdentifier,omitempty"`
+	RecordTTL        int64             `json:"recordTTL,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ProviderSpecific []providerProp    `json:"providerSpecific,omitempty"`
+}
+
+type providerProp struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// changes mirrors ExternalDNS' plan.Changes.
+type changes struct {
+	Create    []*endpoint `json:"Create"`
+	UpdateOld []*endpoint `json:"UpdateOld"`
+	UpdateNew []*endpoint `json:"UpdateNew"`
+	Delete    []*endpoint `json:"Delete"`
+}
+
+// domainFilter mirrors ExternalDNS' endpoint.DomainFilter, returned during
+// negotiation.
+type domainFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// webhook implements the ExternalDNS webhook provider protocol for a
+// single Websupport zone. zone is fully qualified.
+type webhook struct {
+	provider *websupport.Provider
+	zone     string
+}
+
+func (h *webhook) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", h.negotiate)
+	mux.HandleFunc("GET /records", h.getRecords)
+	mux.HandleFunc("POST /records", h.applyChanges)
+	mux.HandleFunc("POST /adjustendpoints", h.adjustEndpoints)
+	return mux
+}
+
+// accepts reports whether the request negotiates the webhook media type,
+// and answers with an error if not.
+func accepts(w http.ResponseWriter, r *http.Request) bool {
+	header, code := "Accept", http.StatusNotAcceptable
+	if r.Method == http.MethodPost {
+		header, code = "Content-Type", http.StatusUnsupportedMediaType
+	}
+	for _, v := range strings.Split(r.Header.Get(header), ",") {
+		if strings.ReplaceAll(strings.TrimSpace(v), " ", "") == mediaType {
+			return true
+		}
+	}
+	http.Error(w, fmt.Sprintf("client must provide %s header %q", header, mediaType), code)
+	return false
+}
+
+func writeWebhookJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", mediaType)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// negotiate tells ExternalDNS which domains this provider serves.
+func (h *webhook) negotiate(w http.ResponseWriter, r *http.Request) {
+	if !accepts(w, r) {
+		return
+	}
+	writeWebhookJSON(w, domainFilter{Include: []string{strings.TrimSuffix(h.zone, ".")}})
+}
+
+// getRecords returns the zone's records as endpoints, one per name and type
+// with all targets of the RRset.
+func (h *webhook) getRecords(w http.ResponseWriter, r *http.Request) {
+	if !accepts(w, r) {
+		return
+	}
+	recs, err := h.provider.GetRecords(r.Context(), h.zone)
+	if err != nil {
+		log.Printf("Failed to get records: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeWebhookJSON(w, toEndpoints(recs, h.zone))
+}
+
+// applyChanges applies a plan: deletions and the old side of updates are
+// removed first, then the new side of updates and creations are added.
+func (h *webhook) applyChanges(w http.ResponseWriter, r *http.Request) {
+	if !accepts(w, r) {
+		return
+	}
+	var c changes
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "invalid changes: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.apply(r.Context(), c); err != nil {
+		log.Printf("Failed to apply changes: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *webhook) apply(ctx context.Context, c changes) error {
+	del := append(c.Delete, c.UpdateOld...)
+	add := append(c.Create, c.UpdateNew...)
+
+	// Targets present on both sides of an update are kept unless their
+	// TTL changed, so updating one target of an RRset doesn't briefly
+	// remove the others. Targets only on the old side are deleted.
+	keep := make(map[string]bool)
+	for i := range c.UpdateOld {
+		if i >= len(c.UpdateNew) || c.UpdateOld[i].RecordTTL != c.UpdateNew[i].RecordTTL {
+			continue
+		}
+		inNew := make(map[string]bool)
+		for _, rec := range fromEndpoint(c.UpdateNew[i], h.zone) {
+			inNew[recordKey(rec)] = true
+		}
+		for _, rec := range fromEndpoint(c.UpdateOld[i], h.zone) {
+			if inNew[recordKey(rec)] {
+				keep[recordKey(rec)] = true
+			}
+		}
+	}
+
+	var toDelete, toCreate []libdns.Record
+	for _, ep := range del {
+		for _, rec := range fromEndpoint(ep, h.zone) {
+			if !keep[recordKey(rec)] {
+				toDelete = append(toDelete, rec)
+			}
+		}
+	}
+	for _, ep := range add {
+		for _, rec := range fromEndpoint(ep, h.zone) {
+			if !keep[recordKey(rec)] {
+				toCreate = append(toCreate, rec)
+			}
+		}
+	}
+
+	if len(toDelete) > 0 {
+		if _, err := h.provider.DeleteRecords(ctx, h.zone, toDelete); err != nil {
+			return fmt.Errorf("failed to delete records: %v", err)
+		}
+	}
+	if len(toCreate) > 0 {
+		if _, err := h.provider.AppendRecords(ctx, h.zone, toCreate); err != nil {
+			return fmt.Errorf("failed to create records: %v", err)
+		}
+	}
+	log.Printf("Applied changes: %d deleted, %d created", len(toDelete), len(toCreate))
+	return nil
+}
+
+// adjustEndpoints lets the provider modify desired endpoints before
+// ExternalDNS plans changes. Websupport has no provider-specific settings,
+// so those are dropped.
+func (h *webhook) adjustEndpoints(w http.ResponseWriter, r *http.Request) {
+	if !accepts(w, r) {
+		return
+	}
+	var eps []*endpoint
+	if err := json.NewDecoder(r.Body).Decode(&eps); err != nil {
+		http.Error(w, "invalid endpoints: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, ep := range eps {
+		ep.ProviderSpecific = nil
+	}
+	writeWebhookJSON(w, eps)
+}
+
+// toEndpoints groups records into endpoints by name and type. TXT targets
+// are quoted, as ExternalDNS' TXT registry expects.
+func toEndpoints(recs []libdns.Record, zone string) []*endpoint {
+	byKey := make(map[string]*endpoint)
+	var eps []*endpoint
+	for _, rec := range recs {
+		rr := rec.RR()
+		if !managed(rr.Name, rr.Type) {
+			continue
+		}
+		name := strings.TrimSuffix(libdns.AbsoluteName(rr.Name, zone), ".")
+		target := rr.Data
+		if rr.Type == "TXT" {
+			target = `"` + target + `"`
+		}
+
+		key := name + " " + rr.Type
+		ep, ok := byKey[key]
+		if !ok {
+			ep = &endpoint{DNSName: name, RecordType: rr.Type, RecordTTL: int64(rr.TTL.Seconds())}
+			byKey[key] = ep
+			eps = append(eps, ep)
+		}
+		ep.Targets = append(ep.Targets, target)
+	}
+	for _, ep := range eps {
+		sort.Strings(ep.Targets)
+	}
+	return eps
+}
+
+// fromEndpoint converts an endpoint into one record per target. Endpoints
+// of records that aren't managed give none.
+func fromEndpoint(ep *endpoint, zone string) []libdns.Record {
+	name := libdns.RelativeName(ep.DNSName+".", zone)
+	if !managed(name, ep.RecordType) {
+		return nil
+	}
+	var recs []libdns.Record
+	for _, target := range ep.Targets {
+		if ep.RecordType == "TXT" && len(target) >= 2 && strings.HasPrefix(target, `"`) && strings.HasSuffix(target, `"`) {
+			target = target[1 : len(target)-1]
+		}
+		recs = append(recs, libdns.RR{
+			Name: name,
+			Type: ep.RecordType,
+			TTL:  time.Duration(ep.RecordTTL) * time.Second,
+			Data: target,
+		})
+	}
+	return recs
+}
+
+func recordKey(rec libdns.Record) string {
+	rr := rec.RR()
+	return rr.Name + " " + rr.Type + " " + rr.Data
+}
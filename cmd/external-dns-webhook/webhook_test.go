@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+	"github.com/libdns/websupport/websupport"
+)
+
+// testWebhook returns a webhook for example.com backed by an in-memory
+// Websupport API holding records.
+func testWebhook(t *testing.T, records ...fakeapi.Record) (*webhook, *fakeapi.API) {
+	t.Helper()
+	api := fakeapi.New(records...)
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	provider := &websupport.Provider{APIKey: "key", APISecret: "secret", ServiceID: "1", APIBase: srv.URL}
+	return &webhook{provider: provider, zone: "example.com."}, api
+}
+
+// contents returns the contents of the zone's records of name and type.
+func contents(api *fakeapi.API, name, typ string) []string {
+	var values []string
+	for _, rec := range api.Records() {
+		if rec.Name == name && rec.Type == typ {
+			values = append(values, rec.Content)
+		}
+	}
+	slices.Sort(values)
+	return values
+}
+
+func TestApplyUpdateChangesTarget(t *testing.T) {
+	h, api := testWebhook(t, fakeapi.Record{Name: "www", Type: "A", Content: "1.1.1.1", TTL: 300})
+
+	err := h.apply(context.Background(), changes{
+		UpdateOld: []*endpoint{{DNSName: "www.example.com", RecordType: "A", RecordTTL: 300, Targets: []string{"1.1.1.1"}}},
+		UpdateNew: []*endpoint{{DNSName: "www.example.com", RecordType: "A", RecordTTL: 300, Targets: []string{"2.2.2.2"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(api, "www", "A"); !slices.Equal(got, []string{"2.2.2.2"}) {
+		t.Errorf("www A = %v, want [2.2.2.2]", got)
+	}
+}
+
+func TestApplyUpdateRemovesTarget(t *testing.T) {
+	h, api := testWebhook(t,
+		fakeapi.Record{Name: "www", Type: "A", Content: "1.1.1.1", TTL: 300},
+		fakeapi.Record{Name: "www", Type: "A", Content: "3.3.3.3", TTL: 300},
+	)
+
+	err := h.apply(context.Background(), changes{
+		UpdateOld: []*endpoint{{DNSName: "www.example.com", RecordType: "A", RecordTTL: 300, Targets: []string{"1.1.1.1", "3.3.3.3"}}},
+		UpdateNew: []*endpoint{{DNSName: "www.example.com", RecordType: "A", RecordTTL: 300, Targets: []string{"1.1.1.1"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(api, "www", "A"); !slices.Equal(got, []string{"1.1.1.1"}) {
+		t.Errorf("www A = %v, want [1.1.1.1]", got)
+	}
+	// The remaining target is left alone rather than recreated.
+	if reqs := api.Requests(); len(reqs) != 1 || reqs[0].Method != http.MethodDelete {
+		t.Errorf("changes %+v, want only the deletion of 3.3.3.3", reqs)
+	}
+}
+
+func TestApplyUpdateChangesTTL(t *testing.T) {
+	h, api := testWebhook(t, fakeapi.Record{Name: "www", Type: "A", Content: "1.1.1.1", TTL: 300})
+
+	err := h.apply(context.Background(), changes{
+		UpdateOld: []*endpoint{{DNSName: "www.example.com", RecordType: "A", RecordTTL: 300, Targets: []string{"1.1.1.1"}}},
+		UpdateNew: []*endpoint{{DNSName: "www.example.com", RecordType: "A", RecordTTL: 600, Targets: []string{"1.1.1.1"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := api.Records()
+	if len(recs) != 1 || recs[0].Content != "1.1.1.1" || recs[0].TTL != 600 {
+		t.Errorf("records %+v, want www A 1.1.1.1 with TTL 600", recs)
+	}
+}
+
+func TestApexNSNotManaged(t *testing.T) {
+	h, api := testWebhook(t,
+		fakeapi.Record{Name: "@", Type: "NS", Content: "ns1.websupport.sk", TTL: 3600},
+		fakeapi.Record{Name: "dev", Type: "NS", Content: "ns1.example.net", TTL: 3600},
+	)
+
+	recs, err := h.provider.GetRecords(context.Background(), h.zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eps := toEndpoints(recs, h.zone)
+	if len(eps) != 1 || eps[0].DNSName != "dev.example.com" {
+		t.Errorf("endpoints %+v, want only the delegation of dev", eps)
+	}
+
+	err = h.apply(context.Background(), changes{
+		Delete: []*endpoint{
+			{DNSName: "example.com", RecordType: "NS", RecordTTL: 3600, Targets: []string{"ns1.websupport.sk"}},
+			{DNSName: "dev.example.com", RecordType: "NS", RecordTTL: 3600, Targets: []string{"ns1.example.net"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(api, "@", "NS"); !slices.Equal(got, []string{"ns1.websupport.sk"}) {
+		t.Errorf("@ NS = %v, want [ns1.websupport.sk]", got)
+	}
+	if got := contents(api, "dev", "NS"); len(got) != 0 {
+		t.Errorf("dev NS = %v, want none", got)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// runDDNS keeps the A/AAAA records of one or more hosts pointed at this
+// machine's public addresses. It checks every -interval and only calls the
+// API to change records when the detected address differs from the zone.
+// Failures are retried with exponential backoff.
+func runDDNS(args []string) {
+	fs := flag.NewFlagSet("ddns", flag.ExitOnError)
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "zone the hosts are in")
+	var hosts stringList
+	fs.Var(&hosts, "host", "host name to update (repeatable)")
+	ipv4 := fs.String("ipv4", "https://api.ipify.org", "IPv4 source: an HTTP(S) echo URL, iface:NAME, upnp, or none")
+	ipv6 := fs.String("ipv6", "none", "IPv6 source: an HTTP(S) echo URL, iface:NAME, or none")
+	interval := fs.Duration("interval", 5*time.Minute, "how often to check the address")
+	maxBackoff := fs.Duration("max-backoff", 30*time.Minute, "longest wait between retries after a failure")
+	ttl := fs.Duration("ttl", 300*time.Second, "TTL of the address records")
+	once := fs.Bool("once", false, "check and update once, then exit")
+	fs.Parse(args)
+
+	if *zoneName == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_TEST_ZONE must be set")
+	}
+	if len(hosts) == 0 {
+		log.Fatal("Error: at least one -host is required")
+	}
+	if *ipv4 == "none" && *ipv6 == "none" {
+		log.Fatal("Error: -ipv4 and -ipv6 are both none")
+	}
+	if *ipv6 == "upnp" {
+		log.Fatal("Error: UPnP gateways only report IPv4 addresses")
+	}
+
+	zone := dns.Fqdn(strings.ToLower(*zoneName))
+	for i, h := range hosts {
+		hosts[i] = dns.Fqdn(strings.ToLower(h))
+		if !dns.IsSubDomain(zone, hosts[i]) {
+			log.Fatalf("Error: host %s is not within zone %s", hosts[i], zone)
+		}
+	}
+	provider := newProviderFromEnv("")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// check detects the addresses and updates the hosts whose records
+	// differ.
+	var last []netip.Addr
+	check := func() error {
+		var addrs []netip.Addr
+		for _, src := range []struct {
+			source string
+			v6     bool
+		}{{*ipv4, false}, {*ipv6, true}} {
+			if src.source == "none" {
+				continue
+			}
+			addr, err := detectAddr(ctx, src.source, src.v6)
+			if err != nil {
+				return fmt.Errorf("failed to detect address from %s: %v", src.source, err)
+			}
+			addrs = append(addrs, addr)
+		}
+		if joinAddrs(addrs) != joinAddrs(last) {
+			log.Printf("🔎 Public address is %s\n", joinAddrs(addrs))
+		}
+
+		for _, host := range hosts {
+			changed, err := setHostAddrs(ctx, provider, zone, host, addrs, *ttl)
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %v", host, err)
+			}
+			if changed {
+				log.Printf("✅ Updated %s to %s\n", host, joinAddrs(addrs))
+			}
+		}
+		last = addrs
+		return nil
+	}
+
+	backoff := time.Duration(0)
+	for {
+		wait := *interval
+		if err := check(); err != nil {
+			if *once {
+				log.Fatalf("❌ %v", err)
+			}
+			backoff = min(max(2*backoff, 15*time.Second), *maxBackoff)
+			wait = backoff
+			log.Printf("⚠️  %v (retrying in %s)\n", err, wait)
+		} else {
+			backoff = 0
+		}
+		if *once {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("👋 Stopping")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// detectAddr returns this machine's public address of the given family
+// from source.
+func detectAddr(ctx context.Context, source string, v6 bool) (netip.Addr, error) {
+	var (
+		addr netip.Addr
+		err  error
+	)
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		addr, err = httpEchoAddr(ctx, source, v6)
+	case strings.HasPrefix(source, "iface:"):
+		addr, err = interfaceAddr(strings.TrimPrefix(source, "iface:"), v6)
+	case source == "upnp":
+		addr, err = upnpExternalAddr(ctx)
+	default:
+		return netip.Addr{}, fmt.Errorf("unknown address source %q", source)
+	}
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if v6 && !addr.Is6() {
+		return netip.Addr{}, fmt.Errorf("got %s, which is not an IPv6 address", addr)
+	}
+	if !v6 && !addr.Is4() {
+		return netip.Addr{}, fmt.Errorf("got %s, which is not an IPv4 address", addr)
+	}
+	return addr, nil
+}
+
+// httpEchoAddr fetches url, which must answer with the client's address as
+// plain text. The connection is forced to the requested family, so dual
+// stack echo services report the right address.
+func httpEchoAddr(ctx context.Context, url string, v6 bool) (netip.Addr, error) {
+	network := "tcp4"
+	if v6 {
+		network = "tcp6"
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	client := &http.Client{Transport: transport, Timeout: 15 * time.Second}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// interfaceAddr returns the first global unicast address of the family on
+// the named interface, preferring public over private addresses.
+func interfaceAddr(name string, v6 bool) (netip.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	var private netip.Addr
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		addr := prefix.Addr().Unmap()
+		if addr.Is6() != v6 || !addr.IsGlobalUnicast() {
+			continue
+		}
+		if !addr.IsPrivate() {
+			return addr, nil
+		}
+		if !private.IsValid() {
+			private = addr
+		}
+	}
+	if private.IsValid() {
+		return private, nil
+	}
+	return netip.Addr{}, fmt.Errorf("no global address on %s", name)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// serveDynDNS runs an HTTP server implementing the dyndns2 update protocol
+// (GET /nic/update) that most routers and NAS boxes speak, replacing the
+// A/AAAA records of the updated hosts.
+func serveDynDNS(args []string) {
+	fs := flag.NewFlagSet("serve dyndns", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "HTTP listen address")
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "zone the hosts are in")
+	usersPath := fs.String("users", "", "users file with lines of user:password:host[,host...]")
+	ttl := fs.Duration("ttl", 300*time.Second, "TTL of the address records")
+	fs.Parse(args)
+
+	if *zoneName == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_TEST_ZONE must be set")
+	}
+	if *usersPath == "" {
+		log.Fatal("Error: -users is required")
+	}
+	users, err := readDynDNSUsers(*usersPath)
+	if err != nil {
+		log.Fatalf("Error: failed to read users: %v", err)
+	}
+
+	s := &dynDNSServer{
+		provider: newProviderFromEnv(""),
+		zone:     dns.Fqdn(strings.ToLower(*zoneName)),
+		users:    users,
+		ttl:      *ttl,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /nic/update", s.update)
+	log.Printf("🌐 Serving dyndns2 updates for %s on %s (%d users)\n", s.zone, *listen, len(users))
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(srv.ListenAndServe())
+}
+
+// dynDNSUser may update the addresses of its hosts. The password is either
+// plain text or "sha256:" followed by the hex digest of the password.
+type dynDNSUser struct {
+	password string
+	hosts    map[string]bool // fully qualified, lowercase
+}
+
+// readDynDNSUsers reads a users file. Empty lines and lines starting with
+// # are ignored.
+func readDynDNSUsers(path string) (map[string]*dynDNSUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]*dynDNSUser)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// The password may itself contain colons (sha256:...), so the
+		// user is split off the front and the hosts off the back.
+		name, rest, ok1 := strings.Cut(line, ":")
+		i := strings.LastIndex(rest, ":")
+		if !ok1 || i < 0 || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:password:host[,host...]", path, n)
+		}
+		u := &dynDNSUser{password: rest[:i], hosts: make(map[string]bool)}
+		for _, host := range strings.Split(rest[i+1:], ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				u.hosts[dns.Fqdn(strings.ToLower(host))] = true
+			}
+		}
+		users[name] = u
+	}
+	return users, scanner.Err()
+}
+
+// checkPassword compares password with the user's in constant time.
+func (u *dynDNSUser) checkPassword(password string) bool {
+	if digest, ok := strings.CutPrefix(u.password, "sha256:"); ok {
+		sum := sha256.Sum256([]byte(password))
+		want, _ := hex.DecodeString(digest)
+		return subtle.ConstantTimeCompare(sum[:], want) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(u.password)) == 1
+}
+
+// dynDNSServer implements the dyndns2 protocol.
+type dynDNSServer struct {
+	provider *websupport.Provider
+	zone     string // fully qualified
+	users    map[string]*dynDNSUser
+	ttl      time.Duration
+}
+
+// update handles /nic/update?hostname=HOST[,HOST...]&myip=IP[,IP]. Without
+// myip the client's address is used; an unparsable one is answered with
+// dnserr. Each host gets one line of reply: good or nochg with the
+// addresses, or a dyndns2 error code.
+func (s *dynDNSServer) update(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	username, password, ok := r.BasicAuth()
+	user := s.users[username]
+	if !ok || user == nil || !user.checkPassword(password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dyndns"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+
+	q := r.URL.Query()
+	var hosts []string
+	for _, h := range strings.Split(q.Get("hostname"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, dns.Fqdn(strings.ToLower(h)))
+		}
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+	if len(hosts) > 20 {
+		fmt.Fprintln(w, "numhost")
+		return
+	}
+
+	addrs, err := dynDNSAddrs(q.Get("myip"), q.Get("myipv6"), r.RemoteAddr)
+	if err != nil {
+		fmt.Fprintln(w, "dnserr")
+		return
+	}
+
+	for _, host := range hosts {
+		switch {
+		case !user.hosts[host] || !dns.IsSubDomain(s.zone, host):
+			fmt.Fprintln(w, "nohost")
+		default:
+			changed, err := setHostAddrs(r.Context(), s.provider, s.zone, host, addrs, s.ttl)
+			switch {
+			case err != nil:
+				log.Printf("❌ Failed to update %s: %v\n", host, err)
+				fmt.Fprintln(w, "911")
+			case changed:
+				log.Printf("✅ %s updated %s to %s\n", username, host, joinAddrs(addrs))
+				fmt.Fprintln(w, "good", joinAddrs(addrs))
+			default:
+				fmt.Fprintln(w, "nochg", joinAddrs(addrs))
+			}
+		}
+	}
+}
+
+// dynDNSAddrs returns the addresses to set, at most one per family.
+func dynDNSAddrs(myip, myipv6, remoteAddr string) ([]netip.Addr, error) {
+	var fields []string
+	for _, v := range strings.Split(myip+","+myipv6, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			fields = append(fields, v)
+		}
+	}
+	if len(fields) == 0 {
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			host = remoteAddr
+		}
+		fields = []string{host}
+	}
+
+	var v4, v6 netip.Addr
+	for _, f := range fields {
+		addr, err := netip.ParseAddr(f)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		if addr.Is4() {
+			v4 = addr
+		} else {
+			v6 = addr
+		}
+	}
+
+	var addrs []netip.Addr
+	for _, a := range []netip.Addr{v4, v6} {
+		if a.IsValid() {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs, nil
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// setHostAddrs replaces the A or AAAA records of host with addrs, touching
+// only the address families given. It reports whether anything changed.
+func setHostAddrs(ctx context.Context, provider *websupport.Provider, zone, host string, addrs []netip.Addr, ttl time.Duration) (bool, error) {
+	current, err := provider.GetRecords(ctx, zone)
+	if err != nil {
+		return false, err
+	}
+	name := libdns.RelativeName(host, zone)
+
+	var toDelete, toAdd []libdns.Record
+	for _, addr := range addrs {
+		typ := "A"
+		if addr.Is6() {
+			typ = "AAAA"
+		}
+
+		var existing []libdns.Record
+		upToDate := false
+		for _, rec := range current {
+			rr := rec.RR()
+			if rr.Name != name || rr.Type != typ {
+				continue
+			}
+			existing = append(existing, rec)
+			if rr.Data == addr.String() {
+				upToDate = true
+			}
+		}
+		if upToDate && len(existing) == 1 {
+			continue
+		}
+		toDelete = append(toDelete, existing...)
+		toAdd = append(toAdd, libdns.Address{Name: name, IP: addr, TTL: ttl})
+	}
+
+	if len(toDelete) > 0 {
+		if _, err := provider.DeleteRecords(ctx, zone, toDelete); err != nil {
+			return false, err
+		}
+	}
+	if len(toAdd) > 0 {
+		if _, err := provider.AppendRecords(ctx, zone, toAdd); err != nil {
+			return false, err
+		}
+	}
+	return len(toAdd) > 0, nil
+}
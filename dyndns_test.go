@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+	"github.com/libdns/websupport/websupport"
+)
+
+// testDynDNS returns a dyndns server for example.com, backed by an
+// in-memory Websupport API holding records, with the user "router"
+// (password "secret") allowed to update home.example.com.
+func testDynDNS(t *testing.T, records ...fakeapi.Record) (*dynDNSServer, *fakeapi.API) {
+	t.Helper()
+	api := fakeapi.New(records...)
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return &dynDNSServer{
+		provider: &websupport.Provider{APIKey: "key", APISecret: "secret", ServiceID: "1", APIBase: srv.URL},
+		zone:     "example.com.",
+		users:    map[string]*dynDNSUser{"router": {password: "secret", hosts: map[string]bool{"home.example.com.": true}}},
+		ttl:      300 * time.Second,
+	}, api
+}
+
+// nicUpdate sends GET /nic/update?query as user and returns the status and
+// reply.
+func (s *dynDNSServer) nicUpdate(user, password, query string) (int, string) {
+	r := httptest.NewRequest("GET", "/nic/update?"+query, nil)
+	r.SetBasicAuth(user, password)
+	w := httptest.NewRecorder()
+	s.update(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestDynDNSAuth(t *testing.T) {
+	s, api := testDynDNS(t)
+	for _, tt := range []struct{ user, password string }{
+		{"router", "wrong"},
+		{"nobody", "secret"},
+	} {
+		code, reply := s.nicUpdate(tt.user, tt.password, "hostname=home.example.com&myip=192.0.2.1")
+		if code != http.StatusUnauthorized || reply != "badauth\n" {
+			t.Errorf("%s/%s: %d %q, want 401 badauth", tt.user, tt.password, code, reply)
+		}
+	}
+	if reqs := api.Requests(); len(reqs) != 0 {
+		t.Errorf("refused updates sent %v", reqs)
+	}
+}
+
+func TestDynDNSUpdate(t *testing.T) {
+	s, api := testDynDNS(t,
+		fakeapi.Record{Name: "home", Type: "A", Content: "192.0.2.1", TTL: 300},
+		fakeapi.Record{Name: "home", Type: "A", Content: "192.0.2.2", TTL: 300},
+		fakeapi.Record{Name: "home", Type: "AAAA", Content: "2001:db8::1", TTL: 300},
+	)
+
+	// Both A records are replaced by the new address, deleted first; the
+	// AAAA record is left alone.
+	_, reply := s.nicUpdate("router", "secret", "hostname=home.example.com&myip=192.0.2.3")
+	if reply != "good 192.0.2.3\n" {
+		t.Errorf("reply %q, want good", reply)
+	}
+	var methods []string
+	for _, req := range api.Requests() {
+		methods = append(methods, req.Method)
+	}
+	if !slices.Equal(methods, []string{"DELETE", "DELETE", "POST"}) {
+		t.Errorf("requests %v, want two deletions, then a creation", methods)
+	}
+	var got []string
+	for _, rec := range api.Records() {
+		got = append(got, rec.Type+" "+rec.Content)
+	}
+	slices.Sort(got)
+	if want := []string{"A 192.0.2.3", "AAAA 2001:db8::1"}; !slices.Equal(got, want) {
+		t.Errorf("records %v, want %v", got, want)
+	}
+
+	// The same address again changes nothing.
+	_, reply = s.nicUpdate("router", "secret", "hostname=home.example.com&myip=192.0.2.3")
+	if reply != "nochg 192.0.2.3\n" {
+		t.Errorf("reply %q, want nochg", reply)
+	}
+	if n := len(api.Requests()); n != 3 {
+		t.Errorf("%d requests after nochg, want still 3", n)
+	}
+}
+
+func TestDynDNSReplies(t *testing.T) {
+	s, api := testDynDNS(t)
+	for _, tt := range []struct{ query, want string }{
+		{"hostname=other.example.com&myip=192.0.2.1", "nohost\n"},
+		{"hostname=home.example.com,other.example.com&myip=192.0.2.1", "good 192.0.2.1\nnohost\n"},
+		{"hostname=&myip=192.0.2.1", "notfqdn\n"},
+		{"hostname=home.example.com&myip=not-an-ip", "dnserr\n"},
+	} {
+		if _, reply := s.nicUpdate("router", "secret", tt.query); reply != tt.want {
+			t.Errorf("%s: reply %q, want %q", tt.query, reply, tt.want)
+		}
+	}
+	if recs := api.Records(); len(recs) != 1 || recs[0].Name != "home" {
+		t.Errorf("records %+v, want only home", recs)
+	}
+}
+
+func TestReadDynDNSUsers(t *testing.T) {
+	sum := sha256.Sum256([]byte("hashed"))
+	path := filepath.Join(t.TempDir(), "users")
+	os.WriteFile(path, []byte("# routers\n\nplain:pass:home.example.com, nas.example.com\nhashed:sha256:"+hex.EncodeToString(sum[:])+":office.example.com\n"), 0600)
+
+	users, err := readDynDNSUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := users["plain"]; u == nil || !u.checkPassword("pass") || !u.hosts["home.example.com."] || !u.hosts["nas.example.com."] {
+		t.Errorf("plain: %+v", u)
+	}
+	if u := users["hashed"]; u == nil || !u.checkPassword("hashed") || u.checkPassword("wrong") || !u.hosts["office.example.com."] {
+		t.Errorf("hashed: %+v", u)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/libdns/websupport/lego"
+	"github.com/libdns/websupport/websupport"
+)
+
+// serveHTTPReq runs an HTTP server implementing the contract of lego's
+// httpreq DNS provider (also used by Traefik), so clients that can't embed
+// Go code can solve DNS-01 challenges through Websupport.
+func serveHTTPReq(args []string) {
+	fs := flag.NewFlagSet("serve httpreq", flag.ExitOnError)
+	listen := fs.String("listen", ":8090", "HTTP listen address")
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "zone the challenge records are created in")
+	username := fs.String("username", os.Getenv("HTTPREQ_USERNAME"), "basic auth user clients must send")
+	password := fs.String("password", os.Getenv("HTTPREQ_PASSWORD"), "basic auth password clients must send")
+	allow := fs.String("allow", "", "comma-separated domains certificates may be requested for; *.example.com allows all subdomains (default: the zone and its subdomains)")
+	fs.Parse(args)
+
+	if *zoneName == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_TEST_ZONE must be set")
+	}
+	if *username == "" || *password == "" {
+		log.Fatal("Error: -username and -password (or HTTPREQ_USERNAME and HTTPREQ_PASSWORD) must be set")
+	}
+
+	zone := dns.Fqdn(strings.ToLower(*zoneName))
+	if *allow == "" {
+		*allow = zone + ",*." + zone
+	}
+	var allowed []string
+	for _, d := range strings.Split(*allow, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			allowed = append(allowed, dns.Fqdn(strings.ToLower(d)))
+		}
+	}
+
+	s := &httpReqServer{
+		provider: websupport.ACMEOnly(newProviderFromEnv("")),
+		zone:     zone,
+		username: *username,
+		password: *password,
+		allowed:  allowed,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /present", s.handle(s.present))
+	mux.HandleFunc("POST /cleanup", s.handle(s.cleanup))
+	log.Printf("🌐 Serving lego httpreq API for %s on %s\n", strings.Join(allowed, ", "), *listen)
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(srv.ListenAndServe())
+}
+
+// httpReqServer implements lego's httpreq contract. Requests are limited
+// to ACME challenge records of allowed domains.
+type httpReqServer struct {
+	provider *websupport.ACMEOnlyProvider
+	zone     string // fully qualified
+	username string
+	password string
+	allowed  []string // fully qualified; "*." prefix matches subdomains
+}
+
+// httpReqRequest is the body of a request: {fqdn, value} by default, or
+// {domain, token, keyAuth} when the client runs with HTTPREQ_MODE=RAW.
+type httpReqRequest struct {
+	FQDN    string `json:"fqdn"`
+	Value   string `json:"value"`
+	Domain  string `json:"domain"`
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
+}
+
+// handle authenticates and decodes a request and passes the challenge
+// record's FQDN and value to fn.
+func (s *httpReqServer) handle(fn func(ctx context.Context, fqdn, value string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="httpreq"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req httpReqRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fqdn, value := req.FQDN, req.Value
+		if req.Domain != "" && req.KeyAuth != "" {
+			fqdn, value = lego.ChallengeInfo(req.Domain, req.KeyAuth)
+		}
+		fqdn = dns.Fqdn(strings.ToLower(fqdn))
+		if value == "" || !strings.HasPrefix(fqdn, "_acme-challenge.") {
+			http.Error(w, "fqdn must be an _acme-challenge name and value must be set", http.StatusBadRequest)
+			return
+		}
+		domain := strings.TrimPrefix(fqdn, "_acme-challenge.")
+		if !dns.IsSubDomain(s.zone, fqdn) || !s.isAllowed(domain) {
+			log.Printf("⚠️  Refused challenge for %s from %s\n", domain, r.RemoteAddr)
+			http.Error(w, fmt.Sprintf("domain %s is not allowed", strings.TrimSuffix(domain, ".")), http.StatusForbidden)
+			return
+		}
+
+		if err := fn(r.Context(), fqdn, value); err != nil {
+			log.Printf("❌ %s %s: %v\n", r.URL.Path, fqdn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✅ %s %s\n", r.URL.Path, fqdn)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// isAllowed reports whether certificates for domain may be requested.
+func (s *httpReqServer) isAllowed(domain string) bool {
+	for _, a := range s.allowed {
+		if a == domain {
+			return true
+		}
+		if parent, ok := strings.CutPrefix(a, "*."); ok && strings.HasSuffix(domain, "."+parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// present creates the challenge TXT record.
+func (s *httpReqServer) present(ctx context.Context, fqdn, value string) error {
+	rec := libdns.TXT{Name: libdns.RelativeName(fqdn, s.zone), Text: value}
+	_, err := s.provider.AppendRecords(ctx, s.zone, []libdns.Record{rec})
+	return err
+}
+
+// cleanup deletes the challenge TXT record. Records that don't exist are
+// not an error, as clients clean up after failed presents too.
+func (s *httpReqServer) cleanup(ctx context.Context, fqdn, value string) error {
+	rec := libdns.TXT{Name: libdns.RelativeName(fqdn, s.zone), Text: value}
+	_, err := s.provider.DeleteRecords(ctx, s.zone, []libdns.Record{rec})
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+	"github.com/libdns/websupport/lego"
+	"github.com/libdns/websupport/websupport"
+)
+
+// testHTTPReq returns an httpreq server for example.com, backed by an
+// in-memory Websupport API, that allows app.example.com and the
+// subdomains of dev.example.com.
+func testHTTPReq(t *testing.T) (http.Handler, *fakeapi.API) {
+	t.Helper()
+	api := fakeapi.New()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	s := &httpReqServer{
+		provider: websupport.ACMEOnly(&websupport.Provider{APIKey: "key", APISecret: "secret", ServiceID: "1", APIBase: srv.URL}),
+		zone:     "example.com.",
+		username: "lego",
+		password: "secret",
+		allowed:  []string{"app.example.com.", "*.dev.example.com."},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /present", s.handle(s.present))
+	mux.HandleFunc("POST /cleanup", s.handle(s.cleanup))
+	return mux, api
+}
+
+func httpReq(h http.Handler, path, password, body string) int {
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	r.SetBasicAuth("lego", password)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestHTTPReqAllowlist(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		password string
+		body     string
+		want     int
+	}{
+		{"wrong password", "wrong", `{"fqdn": "_acme-challenge.app.example.com.", "value": "v"}`, http.StatusUnauthorized},
+		{"allowed", "secret", `{"fqdn": "_acme-challenge.app.example.com.", "value": "v"}`, http.StatusOK},
+		{"allowed by wildcard", "secret", `{"fqdn": "_acme-challenge.x.dev.example.com.", "value": "v"}`, http.StatusOK},
+		{"wildcard parent", "secret", `{"fqdn": "_acme-challenge.dev.example.com.", "value": "v"}`, http.StatusForbidden},
+		{"not allowed", "secret", `{"fqdn": "_acme-challenge.www.example.com.", "value": "v"}`, http.StatusForbidden},
+		{"outside the zone", "secret", `{"fqdn": "_acme-challenge.app.example.org.", "value": "v"}`, http.StatusForbidden},
+		{"lookalike zone", "secret", `{"fqdn": "_acme-challenge.app.badexample.com.", "value": "v"}`, http.StatusForbidden},
+		{"not a challenge", "secret", `{"fqdn": "app.example.com.", "value": "v"}`, http.StatusBadRequest},
+		{"no value", "secret", `{"fqdn": "_acme-challenge.app.example.com."}`, http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api := testHTTPReq(t)
+			if code := httpReq(h, "/present", tt.password, tt.body); code != tt.want {
+				t.Errorf("status %d, want %d", code, tt.want)
+			}
+			if n := len(api.Records()); (n == 1) != (tt.want == http.StatusOK) {
+				t.Errorf("%d records created", n)
+			}
+		})
+	}
+}
+
+func TestHTTPReqRawAndCleanup(t *testing.T) {
+	h, api := testHTTPReq(t)
+	body := `{"domain": "app.example.com", "token": "t", "keyAuth": "t.thumbprint"}`
+	if code := httpReq(h, "/present", "secret", body); code != http.StatusOK {
+		t.Fatalf("present: status %d", code)
+	}
+	_, value := lego.ChallengeInfo("app.example.com", "t.thumbprint")
+	var got []string
+	for _, rec := range api.Records() {
+		got = append(got, rec.Name+" "+rec.Type+" "+rec.Content)
+	}
+	if want := []string{"_acme-challenge.app TXT " + value}; !slices.Equal(got, want) {
+		t.Errorf("records %v, want %v", got, want)
+	}
+
+	if code := httpReq(h, "/cleanup", "secret", body); code != http.StatusOK {
+		t.Fatalf("cleanup: status %d", code)
+	}
+	if recs := api.Records(); len(recs) != 0 {
+		t.Errorf("records %+v left after cleanup", recs)
+	}
+}
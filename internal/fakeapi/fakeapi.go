@@ -0,0 +1,174 @@
+// Package fakeapi is an in-memory stand-in for the DNS record endpoints of
+// the Websupport REST API, for tests that run the provider without real
+// credentials. Serve an API with httptest.NewServer and use the server's
+// URL as the provider's APIBase.
+package fakeapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Record is a DNS record as the API stores it.
+type Record struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+	Prio    int    `json:"prio,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+// Request is an API call the server received.
+type Request struct {
+	Method string
+	Path   string // without the query string
+	APIKey string // the Basic-auth user
+	Record Record // the decoded body of POST and PUT requests
+}
+
+// API serves the records of one zone, under any service ID. Requests are
+// not checked for a valid signature.
+type API struct {
+	mu       sync.Mutex
+	nextID   int
+	records  []Record
+	requests []Request
+}
+
+// New returns an API holding records. Records without an ID are given one.
+func New(records ...Record) *API {
+	a := &API{nextID: 1000}
+	for _, rec := range records {
+		a.add(rec)
+	}
+	return a
+}
+
+func (a *API) add(rec Record) Record {
+	if rec.ID == 0 {
+		a.nextID++
+		rec.ID = a.nextID
+	}
+	a.records = append(a.records, rec)
+	return rec
+}
+
+// Records returns the records currently in the zone.
+func (a *API) Records() []Record {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Record(nil), a.records...)
+}
+
+// Requests returns the calls that changed the zone (POST, PUT and DELETE),
+// in the order they were received.
+func (a *API) Requests() []Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Request(nil), a.requests...)
+}
+
+// ServeHTTP implements the record list, create, update and delete
+// endpoints of /service/{id}/dns/record. A /v2 prefix is ignored.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "service" || parts[2] != "dns" || parts[3] != "record" || len(parts) > 5 {
+		http.NotFound(w, r)
+		return
+	}
+	id := -1
+	if len(parts) == 5 {
+		var err error
+		if id, err = strconv.Atoi(parts[4]); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	req := Request{Method: r.Method, Path: path}
+	req.APIKey, _, _ = r.BasicAuth()
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&req.Record); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"errors": map[string][]string{"body": {err.Error()}}})
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id < 0:
+		a.list(w, r)
+	case r.Method == http.MethodPost && id < 0:
+		a.requests = append(a.requests, req)
+		a.add(req.Record)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && id >= 0:
+		i := a.index(id)
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		a.requests = append(a.requests, req)
+		req.Record.ID = id
+		a.records[i] = req.Record
+		writeJSON(w, http.StatusOK, req.Record)
+	case r.Method == http.MethodDelete && id >= 0:
+		i := a.index(id)
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		a.requests = append(a.requests, req)
+		a.records = append(a.records[:i], a.records[i+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list serves one page of records, like the API's page and rowsPerPage
+// parameters.
+func (a *API) list(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("rowsPerPage"))
+	if perPage < 1 {
+		perPage = 20
+	}
+	pages := max(1, (len(a.records)+perPage-1)/perPage)
+	start := min(len(a.records), (page-1)*perPage)
+	end := min(len(a.records), start+perPage)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"currentPage":  page,
+		"totalPages":   pages,
+		"totalRecords": len(a.records),
+		"data":         append([]Record{}, a.records[start:end]...),
+	})
+}
+
+func (a *API) index(id int) int {
+	for i, rec := range a.records {
+		if rec.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
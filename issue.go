@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/acme"
+
+	"github.com/libdns/websupport/propagation"
+	"github.com/libdns/websupport/websupport"
+)
+
+// letsEncryptStaging is the default ACME directory, so that trying the
+// command out does not run into Let's Encrypt's production rate limits.
+const letsEncryptStaging = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// issueCert obtains a certificate from an ACME (RFC 8555) CA, solving the
+// DNS-01 challenges through the Websupport API.
+func issueCert(args []string) {
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	directory := fs.String("directory", letsEncryptStaging, "ACME directory URL")
+	acmeCA := fs.String("acme-ca", "", "PEM file with the CA of the ACME server's TLS certificate (e.g. Pebble's)")
+	email := fs.String("email", "", "contact email for the ACME account")
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "zone the challenge records are created in")
+	outDir := fs.String("out", ".", "directory to write the certificate chain and key to")
+	keyType := fs.String("key-type", "ecdsa", "certificate key type: ecdsa (P-256) or rsa (2048)")
+	var nameservers stringList
+	fs.Var(&nameservers, "nameserver", "nameserver (host[:port]) to check propagation on instead of the zone's NS records (repeatable)")
+	resolver := fs.String("resolver", "", "resolver (host:port) used to look up the zone's nameservers (default: from /etc/resolv.conf, or the system resolver)")
+	propagationTimeout := fs.Duration("propagation-timeout", 5*time.Minute, "how long to wait for the challenge records to become visible")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: libdns-websupport issue [flags] NAME [NAME...]")
+		fmt.Fprintln(fs.Output(), "")
+		fmt.Fprintln(fs.Output(), "NAMEs may include wildcards, e.g. example.com '*.example.com'.")
+		fmt.Fprintln(fs.Output(), "")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *zoneName == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_TEST_ZONE must be set")
+	}
+	zone := dns.Fqdn(strings.ToLower(*zoneName))
+	names := fs.Args()
+	for _, name := range names {
+		if !dns.IsSubDomain(zone, dns.Fqdn(strings.TrimPrefix(strings.ToLower(name), "*."))) {
+			log.Fatalf("Error: %s is not within zone %s", name, zone)
+		}
+	}
+
+	httpClient := http.DefaultClient
+	if *acmeCA != "" {
+		pemData, err := os.ReadFile(*acmeCA)
+		if err != nil {
+			log.Fatalf("Failed to read -acme-ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			log.Fatalf("No certificates found in %s", *acmeCA)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	accountKey, err := loadOrCreateAccountKey(*directory)
+	if err != nil {
+		log.Fatalf("❌ Failed to load account key: %v", err)
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: *directory, HTTPClient: httpClient}
+	issuer := &issuer{
+		client:   client,
+		provider: websupport.ACMEOnly(newProviderFromEnv("")),
+		zone:     zone,
+		propagation: &propagation.Checker{
+			Nameservers: nameservers,
+			Resolver:    *resolver,
+			Timeout:     *propagationTimeout,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *propagationTimeout+10*time.Minute)
+	defer cancel()
+
+	if err := issuer.register(ctx, *email); err != nil {
+		log.Fatalf("❌ Failed to register ACME account: %v", err)
+	}
+
+	certKey, err := generateKey(*keyType)
+	if err != nil {
+		log.Fatalf("❌ Failed to generate certificate key: %v", err)
+	}
+	chain, err := issuer.issue(ctx, names, certKey)
+	if err != nil {
+		log.Fatalf("❌ Failed to issue certificate: %v", err)
+	}
+
+	certFile, keyFile, err := writeCertificate(*outDir, names[0], chain, certKey)
+	if err != nil {
+		log.Fatalf("❌ Failed to save certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(chain[0])
+	log.Printf("✅ Certificate saved to: %s\n", certFile)
+	log.Printf("✅ Private key saved to: %s\n", keyFile)
+	log.Printf("\n📋 Certificate Details:\n")
+	log.Printf("   Issuer: %s\n", leaf.Issuer.String())
+	log.Printf("   NotAfter: %s\n", leaf.NotAfter.Format(time.RFC3339))
+	log.Printf("   DNS Names: %v\n", leaf.DNSNames)
+}
+
+// issuer runs ACME orders whose DNS-01 challenges are solved in zone.
+type issuer struct {
+	client      *acme.Client
+	provider    *websupport.ACMEOnlyProvider
+	zone        string
+	propagation *propagation.Checker
+}
+
+// register creates the ACME account, or looks it up if the key is already
+// registered.
+func (is *issuer) register(ctx context.Context, email string) error {
+	account := &acme.Account{}
+	if email != "" {
+		account.Contact = []string{"mailto:" + email}
+	}
+	_, err := is.client.Register(ctx, account, acme.AcceptTOS)
+	if errors.Is(err, acme.ErrAccountAlreadyExists) {
+		_, err = is.client.GetReg(ctx, "")
+	}
+	return err
+}
+
+// issue orders a certificate for names and returns its DER chain, leaf
+// first. The challenge records are removed again whether or not the order
+// succeeds.
+func (is *issuer) issue(ctx context.Context, names []string, key crypto.Signer) ([][]byte, error) {
+	log.Printf("📝 Ordering certificate for %s\n", strings.Join(names, ", "))
+	order, err := is.client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return nil, err
+	}
+
+	// Present all DNS-01 challenges first, so that a name and its wildcard
+	// share one propagation wait.
+	var (
+		challenges []*acme.Challenge
+		records    []libdns.Record
+		expected   = make(map[string][]string) // FQDN -> TXT values
+	)
+	defer func() {
+		if len(records) == 0 {
+			return
+		}
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if _, err := is.provider.DeleteRecords(cleanupCtx, is.zone, records); err != nil {
+			log.Printf("⚠️  Failed to clean up challenge records: %v\n", err)
+		} else {
+			log.Printf("🧹 Removed %d challenge records\n", len(records))
+		}
+	}()
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := is.client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var chal *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				chal = c
+			}
+		}
+		if chal == nil {
+			return nil, fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+		}
+		value, err := is.client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		fqdn := "_acme-challenge." + dns.Fqdn(authz.Identifier.Value)
+		created, err := is.provider.AppendRecords(ctx, is.zone, []libdns.Record{libdns.TXT{
+			Name: libdns.RelativeName(fqdn, is.zone),
+			Text: value,
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create challenge record for %s: %v", authz.Identifier.Value, err)
+		}
+		records = append(records, created...)
+		expected[fqdn] = append(expected[fqdn], value)
+		challenges = append(challenges, chal)
+		if authz.Wildcard {
+			log.Printf("🔑 Presented challenge for *.%s\n", authz.Identifier.Value)
+		} else {
+			log.Printf("🔑 Presented challenge for %s\n", authz.Identifier.Value)
+		}
+	}
+
+	log.Printf("⏳ Waiting for %d challenge records to propagate...\n", len(records))
+	for fqdn, values := range expected {
+		if _, err := is.propagation.WaitTXT(ctx, is.zone, fqdn, values...); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, chal := range challenges {
+		if _, err := is.client.Accept(ctx, chal); err != nil {
+			return nil, err
+		}
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if _, err := is.client.WaitAuthorization(ctx, authzURL); err != nil {
+			return nil, err
+		}
+	}
+	log.Println("✅ All challenges validated")
+
+	// The order fetched by WaitOrder has no URI, as it is only sent in
+	// the Location header when the order is created.
+	orderURL := order.URI
+	order, err = is.client.WaitOrder(ctx, orderURL)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: names}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := is.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// While the order is processing, CreateOrderCert polls the
+		// Location of the finalize response, which some CAs (e.g. Pebble)
+		// don't send. Poll the order's own URL instead.
+		if valid, werr := is.client.WaitOrder(ctx, orderURL); werr == nil && valid.Status == acme.StatusValid {
+			return is.client.FetchCert(ctx, valid.CertURL, true)
+		}
+	}
+	return chain, err
+}
+
+// loadOrCreateAccountKey returns the ACME account key for the directory,
+// creating and saving it on first use.
+func loadOrCreateAccountKey(directory string) (crypto.Signer, error) {
+	host := directory
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host, _, _ = strings.Cut(host, "/")
+	path := filepath.Join(stateDir(), "acme", strings.ReplaceAll(host, ":", "_")+".key")
+
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data in %s", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		return key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("🔐 Created ACME account key %s\n", path)
+	return key, nil
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+}
+
+// writeCertificate saves the PEM chain as NAME.crt and the key as NAME.key
+// in dir, where a wildcard NAME is written as _wildcard.
+func writeCertificate(dir, name string, chain [][]byte, key crypto.Signer) (certFile, keyFile string, err error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	base := strings.Replace(strings.ToLower(name), "*", "_wildcard", 1)
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	certFile = filepath.Join(dir, base+".crt")
+	keyFile = filepath.Join(dir, base+".key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
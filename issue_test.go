@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/acme"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+	"github.com/libdns/websupport/propagation"
+	"github.com/libdns/websupport/websupport"
+)
+
+// TestIssuePebble runs the issue flow against a local Pebble ACME server
+// without Websupport credentials: the challenge records are kept in an
+// in-memory stand-in for the Websupport API and served by the rfc2136
+// gateway, which Pebble uses as its DNS server. It needs a pebble binary
+// on PATH or in $PEBBLE, and is skipped otherwise.
+func TestIssuePebble(t *testing.T) {
+	pebble := os.Getenv("PEBBLE")
+	if pebble == "" {
+		var err error
+		if pebble, err = exec.LookPath("pebble"); err != nil {
+			t.Skip("pebble not found; go install github.com/letsencrypt/pebble/v2/cmd/pebble or set PEBBLE")
+		}
+	}
+
+	api := fakeapi.New(fakeapi.Record{Name: "@", Type: "NS", Content: "ns1.websupport.sk", TTL: 600})
+	apiSrv := httptest.NewServer(api)
+	defer apiSrv.Close()
+	provider := &websupport.Provider{APIKey: "key", APISecret: "secret", ServiceID: "1", APIBase: apiSrv.URL}
+
+	nameserver := serveDNS(t, &rfc2136Gateway{provider: provider, zone: "example.com."}, nil)
+
+	directory, acmeHTTP := startPebble(t, pebble, nameserver)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := &issuer{
+		client:   &acme.Client{Key: key, DirectoryURL: directory, HTTPClient: acmeHTTP},
+		provider: websupport.ACMEOnly(provider),
+		zone:     "example.com.",
+		propagation: &propagation.Checker{
+			Nameservers: []string{nameserver},
+			Timeout:     30 * time.Second,
+			Interval:    100 * time.Millisecond,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := is.register(ctx, ""); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	certKey, err := generateKey("ecdsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"example.com", "*.example.com"}
+	chain, err := is.issue(ctx, names, certKey)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(slices.Values(leaf.DNSNames)); !slices.Equal(got, []string{"*.example.com", "example.com"}) {
+		t.Errorf("certificate names %v, want %v", got, names)
+	}
+	for _, rec := range api.Records() {
+		if rec.Type == "TXT" {
+			t.Errorf("challenge record %+v was not cleaned up", rec)
+		}
+	}
+}
+
+// serveDNS serves h over UDP and TCP on the same local port and returns
+// its address. Messages signed with one of tsigSecrets are verified, and
+// UPDATE messages are accepted as by "serve rfc2136".
+func serveDNS(t *testing.T, h dns.Handler, tsigSecrets map[string]string) string {
+	t.Helper()
+	for range 10 {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			pc.Close()
+			continue
+		}
+		for _, srv := range []*dns.Server{
+			{PacketConn: pc, Handler: h, TsigSecret: tsigSecrets, MsgAcceptFunc: acceptUpdates},
+			{Listener: l, Handler: h, TsigSecret: tsigSecrets, MsgAcceptFunc: acceptUpdates},
+		} {
+			go srv.ActivateAndServe()
+			t.Cleanup(func() { srv.Shutdown() })
+		}
+		return pc.LocalAddr().String()
+	}
+	t.Fatal("no local port free for both UDP and TCP")
+	return ""
+}
+
+// startPebble runs pebble with a self-signed certificate, resolving names
+// through nameserver, and returns its directory URL and an HTTP client
+// trusting it.
+func startPebble(t *testing.T, pebble, nameserver string) (string, *http.Client) {
+	t.Helper()
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	listen, management := freePort(t), freePort(t)
+	config, _ := json.Marshal(map[string]any{"pebble": map[string]any{
+		"listenAddress":           listen,
+		"managementListenAddress": management,
+		"certificate":             certFile,
+		"privateKey":              keyFile,
+		"httpPort":                5002,
+		"tlsPort":                 5001,
+		"profiles":                map[string]any{"default": map[string]any{"validityPeriod": 3600}},
+	}})
+	configFile := filepath.Join(dir, "pebble-config.json")
+	os.WriteFile(configFile, config, 0600)
+
+	cmd := exec.Command(pebble, "-config", configFile, "-dnsserver", nameserver)
+	cmd.Env = append(os.Environ(), "PEBBLE_VA_NOSLEEP=1", "PEBBLE_WFE_NONCEREJECT=0")
+	if testing.Verbose() {
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	directory := "https://" + listen + "/dir"
+	for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+		resp, err := client.Get(directory)
+		if err == nil {
+			resp.Body.Close()
+			return directory, client
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("pebble did not start: %v", err)
+		}
+	}
+}
+
+// freePort returns a local address that was free a moment ago.
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
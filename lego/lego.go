@@ -0,0 +1,227 @@
+// Package lego adapts the Websupport provider to lego's DNS-01 challenge
+// provider interface, as used by the lego CLI and Traefik.
+//
+// DNSProvider implements challenge.Provider (Present, CleanUp) and
+// challenge.ProviderTimeout (Timeout) from github.com/go-acme/lego/v4 by
+// method set, so this package does not depend on lego itself.
+package lego
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// Config configures the lego adapter.
+type Config struct {
+	// Zone is the Websupport zone the challenge records are created in.
+	// If empty, it is looked up from the SOA of the challenge FQDN.
+	Zone string
+
+	TTL                int
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+}
+
+// NewDefaultConfig returns a configuration read from the environment:
+// WEBSUPPORT_ZONE, WEBSUPPORT_TTL (seconds), WEBSUPPORT_PROPAGATION_TIMEOUT
+// and WEBSUPPORT_POLLING_INTERVAL (seconds).
+func NewDefaultConfig() *Config {
+	return &Config{
+		Zone:               os.Getenv("WEBSUPPORT_ZONE"),
+		TTL:                envInt("WEBSUPPORT_TTL", 120),
+		PropagationTimeout: time.Duration(envInt("WEBSUPPORT_PROPAGATION_TIMEOUT", 120)) * time.Second,
+		PollingInterval:    time.Duration(envInt("WEBSUPPORT_POLLING_INTERVAL", 5)) * time.Second,
+	}
+}
+
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
+	}
+	return fallback
+}
+
+// DNSProvider solves DNS-01 challenges through the Websupport API.
+type DNSProvider struct {
+	provider *websupport.Provider
+	config   *Config
+
+	mu      sync.Mutex
+	records map[string]libdns.Record // by FQDN and value, for CleanUp
+}
+
+// NewDNSProvider returns a DNSProvider using the WEBSUPPORT_API_KEY,
+// WEBSUPPORT_API_SECRET and WEBSUPPORT_SERVICE_ID environment variables and
+// NewDefaultConfig. As with lego's own providers, the key and secret may
+// instead be read from the files named by WEBSUPPORT_API_KEY_FILE and
+// WEBSUPPORT_API_SECRET_FILE.
+func NewDNSProvider() (*DNSProvider, error) {
+	if _, err := (websupport.EnvCredentials{}).Credentials(context.Background()); err != nil {
+		return nil, fmt.Errorf("websupport: %v", err)
+	}
+	p := &websupport.Provider{
+		APIBase:          os.Getenv("WEBSUPPORT_API_BASE"),
+		ServiceID:        os.Getenv("WEBSUPPORT_SERVICE_ID"),
+		CredentialSource: websupport.EnvCredentials{},
+	}
+	return NewDNSProviderConfig(p, NewDefaultConfig())
+}
+
+// NewDNSProviderConfig returns a DNSProvider wrapping p.
+func NewDNSProviderConfig(p *websupport.Provider, config *Config) (*DNSProvider, error) {
+	if p == nil {
+		return nil, fmt.Errorf("websupport: provider is missing")
+	}
+	if (p.APIKey == "" || p.APISecret == "") && p.CredentialSource == nil {
+		return nil, fmt.Errorf("websupport: API key and secret are required")
+	}
+	if p.ServiceID == "" {
+		return nil, fmt.Errorf("websupport: service ID is required")
+	}
+	if config == nil {
+		config = NewDefaultConfig()
+	}
+	return &DNSProvider{provider: p, config: config, records: make(map[string]libdns.Record)}, nil
+}
+
+// Timeout returns the timeout and interval lego uses to check for
+// propagation of the challenge record.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+// Present creates the TXT record that fulfils the DNS-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value := ChallengeInfo(domain, keyAuth)
+	zone, err := d.zone(fqdn)
+	if err != nil {
+		return err
+	}
+
+	rec := &libdns.TXT{
+		Name: libdns.RelativeName(fqdn, zone),
+		Text: value,
+		TTL:  time.Duration(d.config.TTL) * time.Second,
+	}
+	created, err := d.provider.AppendRecords(context.Background(), zone, []libdns.Record{rec})
+	if err != nil {
+		return fmt.Errorf("websupport: failed to create TXT record for %s: %w", fqdn, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(created) > 0 {
+		d.records[fqdn+" "+value] = created[0]
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record created by Present.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value := ChallengeInfo(domain, keyAuth)
+	zone, err := d.zone(fqdn)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	rec, ok := d.records[fqdn+" "+value]
+	delete(d.records, fqdn+" "+value)
+	d.mu.Unlock()
+	if !ok {
+		// Not created by this instance; delete by content.
+		rec = &libdns.TXT{Name: libdns.RelativeName(fqdn, zone), Text: value}
+	}
+
+	if _, err := d.provider.DeleteRecords(context.Background(), zone, []libdns.Record{rec}); err != nil {
+		return fmt.Errorf("websupport: failed to delete TXT record for %s: %w", fqdn, err)
+	}
+	return nil
+}
+
+// ChallengeInfo returns the FQDN and TXT value of the DNS-01 challenge for
+// domain, as defined in RFC 8555 §8.4: the record lives at _acme-challenge
+// under the domain (without any wildcard label) and holds the unpadded
+// base64url SHA-256 digest of the key authorization.
+func ChallengeInfo(domain, keyAuth string) (fqdn, value string) {
+	sum := sha256.Sum256([]byte(keyAuth))
+	domain = strings.TrimPrefix(dns.Fqdn(domain), "*.")
+	return "_acme-challenge." + domain, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// zone returns the configured zone or looks it up for fqdn.
+func (d *DNSProvider) zone(fqdn string) (string, error) {
+	if d.config.Zone != "" {
+		return dns.Fqdn(d.config.Zone), nil
+	}
+	zone, err := findZoneByFqdn(fqdn)
+	if err != nil {
+		return "", fmt.Errorf("websupport: could not find zone for %s: %w", fqdn, err)
+	}
+	return zone, nil
+}
+
+// findZoneByFqdn walks up from fqdn asking the system resolvers for an SOA
+// record, and returns the name of the first zone apex found.
+func findZoneByFqdn(fqdn string) (string, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return findZoneByNS(fqdn)
+	}
+	client := new(dns.Client)
+	for _, name := range parentDomains(dns.Fqdn(fqdn)) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeSOA)
+		msg.RecursionDesired = true
+		for _, server := range conf.Servers {
+			in, _, err := client.Exchange(msg, net.JoinHostPort(server, conf.Port))
+			if err != nil || in.Rcode != dns.RcodeSuccess {
+				continue
+			}
+			for _, rr := range in.Answer {
+				if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, name) {
+					return soa.Hdr.Name, nil
+				}
+			}
+			break
+		}
+	}
+	return "", fmt.Errorf("no SOA record found")
+}
+
+// findZoneByNS is findZoneByFqdn for systems without /etc/resolv.conf,
+// such as Windows. The Go resolver cannot look up SOA records, so the zone
+// apex is taken to be the first name with NS records.
+func findZoneByNS(fqdn string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, name := range parentDomains(dns.Fqdn(fqdn)) {
+		if ns, err := net.DefaultResolver.LookupNS(ctx, name); err == nil && len(ns) > 0 {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no NS records found")
+}
+
+// parentDomains returns fqdn and each of its parent domains, excluding the
+// root.
+func parentDomains(fqdn string) []string {
+	var names []string
+	for off, end := 0, false; !end; off, end = dns.NextLabel(fqdn, off) {
+		names = append(names, fqdn[off:])
+	}
+	return names
+}
@@ -0,0 +1,34 @@
+package lego
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestChallengeInfo(t *testing.T) {
+	// The token of RFC 8555 section 8.4 and the JWK thumbprint of RFC 7638
+	// section 3.1; the digest was computed with openssl.
+	const keyAuth = "evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA.NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	const value = "ZTRx1Ckl1-tM05o5zaizTTA0yUy5AGereMgSNWC6Ll8"
+
+	for _, tt := range []struct {
+		domain, fqdn string
+	}{
+		{"www.example.org", "_acme-challenge.www.example.org."},
+		{"www.example.org.", "_acme-challenge.www.example.org."},
+		{"*.example.org", "_acme-challenge.example.org."},
+	} {
+		fqdn, got := ChallengeInfo(tt.domain, keyAuth)
+		if fqdn != tt.fqdn || got != value {
+			t.Errorf("ChallengeInfo(%q) = %q, %q; want %q, %q", tt.domain, fqdn, got, tt.fqdn, value)
+		}
+	}
+}
+
+func TestParentDomains(t *testing.T) {
+	got := parentDomains("_acme-challenge.www.example.org.")
+	want := []string{"_acme-challenge.www.example.org.", "www.example.org.", "example.org.", "org."}
+	if !slices.Equal(got, want) {
+		t.Errorf("parentDomains = %q, want %q", got, want)
+	}
+}
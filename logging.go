@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+)
+
+var (
+	logFormat = flag.String("log-format", "text", "log output format: text or json")
+	verbose   = flag.Bool("verbose", false, "log every Websupport API call")
+)
+
+// providerLogger is the logger given to providers created by
+// newProviderFromEnv, nil unless structured or verbose logging was asked
+// for.
+var providerLogger *slog.Logger
+
+// setupLogging configures the standard and structured loggers from the
+// global flags. With -log-format json, log.Printf output is written as
+// JSON records too.
+func setupLogging() {
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	switch *logFormat {
+	case "text":
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		log.Fatalf("Error: unknown -log-format %q (want text or json)", *logFormat)
+	}
+	if *verbose || *logFormat == "json" {
+		providerLogger = slog.Default()
+	}
+}
@@ -7,51 +7,33 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
-	"encoding/json"
 	"encoding/pem"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math/big"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/libdns/websupport/propagation"
 	"github.com/libdns/websupport/websupport"
 
 	"github.com/libdns/libdns"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: libdns-websupport <command> [args]")
-		fmt.Println("")
-		fmt.Println("Commands:")
-		fmt.Println("  test              - Test basic DNS record operations")
-		fmt.Println("  create-cert       - Create a self-signed certificate (local testing only, NOT Let's Encrypt)")
-		fmt.Println("  acme-test         - Simulate ACME DNS-01 challenge (does NOT obtain real certificate)")
-		fmt.Println("")
-		fmt.Println("⚠️  Note: These commands are for TESTING only. To get real Let's Encrypt certificates,")
-		fmt.Println("    use this provider with Caddy, Traefik, Certbot, or another ACME client.")
-		fmt.Println("")
-		fmt.Println("Required Environment Variables:")
-		fmt.Println("  WEBSUPPORT_API_KEY       - Your Websupport API key")
-		fmt.Println("  WEBSUPPORT_API_SECRET    - Your Websupport API secret")
-		fmt.Println("  WEBSUPPORT_SERVICE_ID    - Numeric service ID for your domain")
-		fmt.Println("  WEBSUPPORT_TEST_ZONE     - Your domain name (e.g., example.com)")
-		fmt.Println("")
-		fmt.Println("Example:")
-		fmt.Println("  export WEBSUPPORT_API_KEY=\"your-api-key\"")
-		fmt.Println("  export WEBSUPPORT_API_SECRET=\"your-api-secret\"")
-		fmt.Println("  export WEBSUPPORT_SERVICE_ID=\"1234567\"")
-		fmt.Println("  export WEBSUPPORT_TEST_ZONE=\"example.com\"")
-		fmt.Println("  ./libdns-websupport test")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
 		os.Exit(1)
 	}
+	setupLogging()
 
-	command := os.Args[1]
+	command := args[0]
 
 	switch command {
 	case "test":
@@ -60,32 +42,112 @@ func main() {
 		createSelfSignedCert()
 	case "acme-test":
 		testACMEChallenge()
+	case "issue":
+		issueCert(args[1:])
+	case "zone":
+		runZone(args[1:])
+	case "ddns":
+		runDDNS(args[1:])
+	case "serve":
+		runServe(args[1:])
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		os.Exit(1)
 	}
 }
 
-// testBasicOperations tests basic DNS record operations
-func testBasicOperations() {
+// usage prints the commands, global flags and environment variables.
+func usage() {
+	fmt.Println("Usage: libdns-websupport [-verbose] [-log-format text|json] <command> [args]")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  test              - Test basic DNS record operations")
+	fmt.Println("  create-cert       - Create a self-signed certificate (local testing only, NOT Let's Encrypt)")
+	fmt.Println("  acme-test         - Simulate ACME DNS-01 challenge (does NOT obtain real certificate)")
+	fmt.Println("  issue NAME...     - Obtain a real certificate from an ACME CA (Let's Encrypt staging by default)")
+	fmt.Println("  zone diff A B     - Compare two record sets (websupport[:SERVICE_ID], zone file, .json, .yaml)")
+	fmt.Println("  zone snapshot     - Save the full record set of the zone to a timestamped JSON file")
+	fmt.Println("  zone restore FILE - Bring the zone back to the state of a snapshot")
+	fmt.Println("  ddns              - Keep A/AAAA records pointed at this machine's public address")
+	fmt.Println("  serve rfc2136     - Accept TSIG-signed RFC 2136 dynamic updates and apply them to the zone")
+	fmt.Println("  serve acme-dns    - Run an acme-dns compatible API with per-client challenge credentials")
+	fmt.Println("  serve dyndns      - Accept dyndns2 (/nic/update) address updates from routers")
+	fmt.Println("  serve httpreq     - Serve lego/Traefik httpreq /present and /cleanup for allowed domains")
+	fmt.Println("")
+	fmt.Println("Global flags (before the command):")
+	fmt.Println("  -verbose          - Log every Websupport API call (method, path, status, latency)")
+	fmt.Println("  -log-format FMT   - Log as text (default) or json")
+	fmt.Println("")
+	fmt.Println("⚠️  Note: test, create-cert and acme-test are for TESTING only. To get real Let's Encrypt")
+	fmt.Println("    certificates, use issue or this provider with Caddy, Traefik, Certbot, or another ACME client.")
+	fmt.Println("")
+	fmt.Println("Required Environment Variables:")
+	fmt.Println("  WEBSUPPORT_API_KEY       - Your Websupport API key")
+	fmt.Println("  WEBSUPPORT_API_SECRET    - Your Websupport API secret")
+	fmt.Println("  WEBSUPPORT_SERVICE_ID    - Numeric service ID for your domain")
+	fmt.Println("  WEBSUPPORT_TEST_ZONE     - Your domain name (e.g., example.com)")
+	fmt.Println("")
+	fmt.Println("Example:")
+	fmt.Println("  export WEBSUPPORT_API_KEY=\"your-api-key\"")
+	fmt.Println("  export WEBSUPPORT_API_SECRET=\"your-api-secret\"")
+	fmt.Println("  export WEBSUPPORT_SERVICE_ID=\"1234567\"")
+	fmt.Println("  export WEBSUPPORT_TEST_ZONE=\"example.com\"")
+	fmt.Println("  ./libdns-websupport test")
+}
+
+// newProviderFromEnv creates a provider from the WEBSUPPORT_* environment
+// variables and exits if any of the required ones is missing. A non-empty
+// serviceID takes precedence over WEBSUPPORT_SERVICE_ID.
+//
+// The credentials are read from the output of WEBSUPPORT_CREDENTIALS_COMMAND
+// if set, from systemd credentials if the service has them and
+// WEBSUPPORT_API_KEY isn't set, and from WEBSUPPORT_API_KEY and
+// WEBSUPPORT_API_SECRET (or their _FILE variants) otherwise.
+func newProviderFromEnv(serviceID string) *websupport.Provider {
 	provider := &websupport.Provider{
-		APIKey:    os.Getenv("WEBSUPPORT_API_KEY"),
-		APISecret: os.Getenv("WEBSUPPORT_API_SECRET"),
 		APIBase:   "https://rest.websupport.sk/v2",
 		ServiceID: os.Getenv("WEBSUPPORT_SERVICE_ID"),
+		Logger:    providerLogger,
 	}
 	// SECURITY NOTE:
 	// This demo loads credentials from environment variables.
 	// Ensure you do not commit real API keys or secrets to Git.
 	// Set `WEBSUPPORT_API_KEY` and `WEBSUPPORT_API_SECRET` locally before running.
+	switch {
+	case os.Getenv("WEBSUPPORT_CREDENTIALS_COMMAND") != "":
+		provider.CredentialSource = websupport.CommandCredentials{
+			Command: "/bin/sh",
+			Args:    []string{"-c", os.Getenv("WEBSUPPORT_CREDENTIALS_COMMAND")},
+		}
+	case os.Getenv("CREDENTIALS_DIRECTORY") != "" && os.Getenv("WEBSUPPORT_API_KEY") == "" && os.Getenv("WEBSUPPORT_API_KEY_FILE") == "":
+		provider.CredentialSource = websupport.SystemdCredentials{}
+	default:
+		provider.CredentialSource = websupport.EnvCredentials{}
+	}
+	if serviceID != "" {
+		provider.ServiceID = serviceID
+	}
+	if apiBase := os.Getenv("WEBSUPPORT_API_BASE"); apiBase != "" {
+		provider.APIBase = apiBase
+	}
 
-	if provider.APIKey == "" || provider.APISecret == "" {
-		log.Fatal("Error: WEBSUPPORT_API_KEY and WEBSUPPORT_API_SECRET environment variables must be set")
+	// Fail early on missing credentials; the command is left to run on
+	// first use, as it may prompt.
+	if _, ok := provider.CredentialSource.(websupport.CommandCredentials); !ok {
+		if _, err := provider.CredentialSource.Credentials(context.Background()); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 	}
 
 	if provider.ServiceID == "" {
 		log.Fatal("Error: WEBSUPPORT_SERVICE_ID environment variable must be set")
 	}
+	return provider
+}
+
+// testBasicOperations tests basic DNS record operations
+func testBasicOperations() {
+	provider := newProviderFromEnv("")
 
 	ctx := context.Background()
 	zone := os.Getenv("WEBSUPPORT_TEST_ZONE")
@@ -116,7 +178,7 @@ func testBasicOperations() {
 	if err != nil {
 		log.Fatalf("❌ Failed to get records: %v", err)
 	}
-	log.Printf("✅ Found %d TXT records\n", len(records))
+	log.Printf("✅ Found %d records\n", len(records))
 	for _, rec := range records {
 		if txtRec, ok := rec.(*libdns.TXT); ok {
 			log.Printf("   - Name: %s, Text: %s, TTL: %v, ID: %v\n",
@@ -220,23 +282,7 @@ func createSelfSignedCert() {
 
 // testACMEChallenge simulates an ACME DNS-01 challenge
 func testACMEChallenge() {
-	provider := &websupport.Provider{
-		APIKey:    os.Getenv("WEBSUPPORT_API_KEY"),
-		APISecret: os.Getenv("WEBSUPPORT_API_SECRET"),
-		APIBase:   "https://rest.websupport.sk/v2",
-		ServiceID: os.Getenv("WEBSUPPORT_SERVICE_ID"),
-	}
-	// SECURITY NOTE:
-	// Use environment variables or a secrets manager for credentials.
-	// Never hardcode or commit sensitive values.
-
-	if provider.APIKey == "" || provider.APISecret == "" {
-		log.Fatal("Error: WEBSUPPORT_API_KEY and WEBSUPPORT_API_SECRET environment variables must be set")
-	}
-
-	if provider.ServiceID == "" {
-		log.Fatal("Error: WEBSUPPORT_SERVICE_ID environment variable must be set")
-	}
+	provider := newProviderFromEnv("")
 
 	ctx := context.Background()
 	zone := os.Getenv("WEBSUPPORT_TEST_ZONE")
@@ -254,9 +300,11 @@ func testACMEChallenge() {
 	// Step 1: Create challenge record
 	log.Printf("\n1️⃣  Creating DNS challenge record for: %s\n", domain)
 	challengeValue := base64.RawURLEncoding.EncodeToString([]byte("test-acme-challenge-value-" + fmt.Sprintf("%d", time.Now().Unix())))
+	dnsName := fmt.Sprintf("_acme-challenge.%s.", strings.TrimSuffix(domain, "."))
+	challengeName := libdns.RelativeName(dnsName, zone+".")
 
 	challengeRecord := &libdns.TXT{
-		Name: "_acme-challenge",
+		Name: challengeName,
 		Text: challengeValue,
 		TTL:  120 * time.Second,
 	}
@@ -267,21 +315,21 @@ func testACMEChallenge() {
 	}
 	log.Printf("✅ Created challenge record with ID: %v\n", created[0].(*libdns.TXT).ProviderData)
 
-	// Step 2: Wait for DNS propagation
-	log.Println("\n2️⃣  Waiting for DNS propagation (5 seconds)...")
-	time.Sleep(5 * time.Second)
-
-	// Step 3: Verify DNS record exists
-	log.Println("\n3️⃣  Verifying DNS record via public DNS lookup...")
-	dnsName := fmt.Sprintf("_acme-challenge.%s", domain)
-	if testDNSLookup(dnsName, challengeValue) {
-		log.Printf("✅ DNS record verified publicly!\n")
+	// Step 2: Wait for the record to reach the authoritative nameservers
+	log.Println("\n2️⃣  Waiting for DNS propagation to the authoritative nameservers...")
+	checker := &propagation.Checker{Timeout: 2 * time.Minute}
+	results, err := checker.WaitTXT(ctx, zone, dnsName, challengeValue)
+	for _, r := range results {
+		log.Printf("   %s\n", r)
+	}
+	if err != nil {
+		log.Printf("⚠️  DNS record not yet served by all nameservers: %v\n", err)
 	} else {
-		log.Printf("⚠️  DNS record not yet publicly available (this is normal for recent changes)\n")
+		log.Printf("✅ DNS record served by all %d nameservers!\n", len(results))
 	}
 
-	// Step 4: Retrieve records
-	log.Println("\n4️⃣  Retrieving all records from API...")
+	// Step 3: Retrieve records
+	log.Println("\n3️⃣  Retrieving all records from API...")
 	records, err := provider.GetRecords(ctx, zone)
 	if err != nil {
 		log.Fatalf("❌ Failed to get records: %v", err)
@@ -290,7 +338,7 @@ func testACMEChallenge() {
 	found := false
 	for _, rec := range records {
 		if txtRec, ok := rec.(*libdns.TXT); ok {
-			if txtRec.Name == "_acme-challenge" && strings.Contains(txtRec.Text, "test-acme-challenge") {
+			if txtRec.Name == challengeName && strings.Contains(txtRec.Text, challengeValue) {
 				log.Printf("✅ Found challenge record: %s = %s\n", txtRec.Name, txtRec.Text)
 				found = true
 			}
@@ -301,8 +349,8 @@ func testACMEChallenge() {
 		log.Printf("⚠️  Challenge record not found in API response\n")
 	}
 
-	// Step 5: Clean up
-	log.Println("\n5️⃣  Cleaning up (deleting challenge record)...")
+	// Step 4: Clean up
+	log.Println("\n4️⃣  Cleaning up (deleting challenge record)...")
 	deleted, err := provider.DeleteRecords(ctx, zone, created)
 	if err != nil {
 		log.Fatalf("❌ Failed to delete challenge record: %v", err)
@@ -311,40 +359,3 @@ func testACMEChallenge() {
 
 	log.Println("\n✨ ACME DNS-01 test completed successfully!")
 }
-
-// testDNSLookup attempts to verify DNS record via public DNS
-func testDNSLookup(name, expectedValue string) bool {
-	// Try using Google DNS API
-	url := fmt.Sprintf("https://8.8.8.8:443/resolve?name=%s&type=TXT", name)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return false
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return false
-	}
-
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return false
-	}
-
-	if answers, ok := result["Answer"].([]interface{}); ok {
-		for _, answer := range answers {
-			if answerMap, ok := answer.(map[string]interface{}); ok {
-				if data, ok := answerMap["data"].(string); ok {
-					if strings.Contains(data, expectedValue) {
-						return true
-					}
-				}
-			}
-		}
-	}
-
-	return false
-}
@@ -0,0 +1,365 @@
+// Package propagation checks that records have reached every
+// authoritative nameserver of a zone, by querying each of them directly
+// instead of going through a (caching) public resolver.
+package propagation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+// Checker queries a zone's authoritative nameservers for records. The zero
+// value looks the nameservers up from the zone's NS records using the
+// system resolver.
+type Checker struct {
+	// Nameservers to query, as host or host:port. If empty, the zone's
+	// authoritative nameservers are found from its NS records.
+	Nameservers []string
+
+	// Resolver (host:port) used to look up NS records and nameserver
+	// addresses. Default: the first server in /etc/resolv.conf, or the
+	// system resolver where there is none (e.g. on Windows).
+	Resolver string
+
+	// Port of nameservers given or found without one. Default: 53.
+	Port string
+
+	// Timeout bounds Wait. Default: 2 minutes.
+	Timeout time.Duration
+
+	// Interval between rounds of queries in Wait. Default: 5 seconds.
+	Interval time.Duration
+
+	// QueryTimeout bounds each single query. Default: 5 seconds.
+	QueryTimeout time.Duration
+}
+
+// Result is what one nameserver served in a round of queries.
+type Result struct {
+	Nameserver string      // host:port
+	Missing    []libdns.RR // expected records the nameserver did not serve
+	Err        error       // query error, if any
+}
+
+// OK reports whether the nameserver served all expected records.
+func (r Result) OK() bool {
+	return r.Err == nil && len(r.Missing) == 0
+}
+
+func (r Result) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s: %v", r.Nameserver, r.Err)
+	case len(r.Missing) > 0:
+		missing := make([]string, len(r.Missing))
+		for i, rr := range r.Missing {
+			missing[i] = fmt.Sprintf("%s %s %q", rr.Name, rr.Type, rr.Data)
+		}
+		return fmt.Sprintf("%s: missing %s", r.Nameserver, strings.Join(missing, ", "))
+	default:
+		return r.Nameserver + ": ok"
+	}
+}
+
+// Error is returned by Wait when the records have not reached every
+// nameserver in time.
+type Error struct {
+	Zone    string
+	Lagging []Result // the nameservers that did not serve all records
+	Err     error    // why waiting stopped: a timeout or the context's error
+}
+
+func (e *Error) Error() string {
+	lagging := make([]string, len(e.Lagging))
+	for i, r := range e.Lagging {
+		lagging[i] = r.String()
+	}
+	return fmt.Sprintf("records not propagated to all nameservers of %s (%v): %s",
+		strings.TrimSuffix(e.Zone, "."), e.Err, strings.Join(lagging, "; "))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wait queries the nameservers of zone every Interval until all of them
+// serve recs, and returns the results of the last round. Record names are
+// relative to zone. A nameserver may serve more records than expected;
+// only the presence of recs is checked. If the records are not served
+// everywhere within Timeout, or ctx ends first, the error is an *Error
+// listing the lagging nameservers.
+func (c *Checker) Wait(ctx context.Context, zone string, recs []libdns.RR) ([]Result, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Minute
+	}
+	interval := c.Interval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	nameservers, err := c.nameservers(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		results := c.check(ctx, nameservers, zone, recs)
+		var lagging []Result
+		for _, r := range results {
+			if !r.OK() {
+				lagging = append(lagging, r)
+			}
+		}
+		if len(lagging) == 0 {
+			return results, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return results, &Error{Zone: dns.Fqdn(zone), Lagging: lagging, Err: ctx.Err()}
+		case <-time.After(interval):
+		}
+	}
+}
+
+// WaitTXT is Wait for TXT records with the given values at fqdn.
+func (c *Checker) WaitTXT(ctx context.Context, zone, fqdn string, values ...string) ([]Result, error) {
+	recs := make([]libdns.RR, len(values))
+	for i, v := range values {
+		recs[i] = libdns.RR{Name: libdns.RelativeName(dns.Fqdn(fqdn), dns.Fqdn(zone)), Type: "TXT", Data: v}
+	}
+	return c.Wait(ctx, zone, recs)
+}
+
+// Check queries every nameserver of zone once for recs.
+func (c *Checker) Check(ctx context.Context, zone string, recs []libdns.RR) ([]Result, error) {
+	nameservers, err := c.nameservers(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	return c.check(ctx, nameservers, zone, recs), nil
+}
+
+// AuthoritativeNameservers returns the addresses (host:port) of the zone's
+// authoritative nameservers, or Nameservers if set.
+func (c *Checker) AuthoritativeNameservers(ctx context.Context, zone string) ([]string, error) {
+	return c.nameservers(ctx, zone)
+}
+
+func (c *Checker) check(ctx context.Context, nameservers []string, zone string, recs []libdns.RR) []Result {
+	zone = dns.Fqdn(zone)
+
+	// Group the expected records by name and type, one query each
+	type question struct{ name, typ string }
+	var questions []question
+	want := make(map[question][]libdns.RR)
+	for _, rr := range recs {
+		q := question{strings.ToLower(libdns.AbsoluteName(rr.Name, zone)), strings.ToUpper(rr.Type)}
+		if _, ok := want[q]; !ok {
+			questions = append(questions, q)
+		}
+		want[q] = append(want[q], rr)
+	}
+
+	results := make([]Result, len(nameservers))
+	done := make(chan struct{})
+	for i, ns := range nameservers {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			results[i].Nameserver = ns
+			for _, q := range questions {
+				have, err := c.query(ctx, ns, q.name, dns.StringToType[q.typ])
+				if err != nil {
+					results[i].Err = err
+					return
+				}
+				for _, rr := range want[q] {
+					if !have[normalizeData(q.typ, rr.Data)] {
+						results[i].Missing = append(results[i].Missing, rr)
+					}
+				}
+			}
+		}()
+	}
+	for range nameservers {
+		<-done
+	}
+	return results
+}
+
+// query asks ns for the records of name and type, without recursion, and
+// returns their normalised data. Truncated UDP answers are retried over
+// TCP.
+func (c *Checker) query(ctx context.Context, ns, name string, qtype uint16) (map[string]bool, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = false
+
+	in, err := c.exchange(ctx, "udp", msg, ns)
+	if err == nil && in.Truncated {
+		in, err = c.exchange(ctx, "tcp", msg, ns)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s answered %s", ns, dns.RcodeToString[in.Rcode])
+	}
+
+	have := make(map[string]bool)
+	for _, rr := range in.Answer {
+		if rr.Header().Rrtype != qtype || !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		have[normalizeData(dns.TypeToString[qtype], rdata(rr))] = true
+	}
+	return have, nil
+}
+
+func (c *Checker) exchange(ctx context.Context, network string, msg *dns.Msg, server string) (*dns.Msg, error) {
+	timeout := c.QueryTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	client := &dns.Client{Net: network, Timeout: timeout}
+	in, _, err := client.ExchangeContext(ctx, msg, server)
+	return in, err
+}
+
+// nameservers returns the nameservers to query for zone.
+func (c *Checker) nameservers(ctx context.Context, zone string) ([]string, error) {
+	port := c.Port
+	if port == "" {
+		port = "53"
+	}
+	if len(c.Nameservers) > 0 {
+		servers := make([]string, len(c.Nameservers))
+		for i, ns := range c.Nameservers {
+			if _, _, err := net.SplitHostPort(ns); err != nil {
+				ns = net.JoinHostPort(ns, port)
+			}
+			servers[i] = ns
+		}
+		return servers, nil
+	}
+
+	resolver := c.Resolver
+	if resolver == "" {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err == nil && len(conf.Servers) > 0 {
+			resolver = net.JoinHostPort(conf.Servers[0], conf.Port)
+		}
+	}
+
+	hosts, err := c.lookup(ctx, resolver, dns.Fqdn(zone), dns.TypeNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up nameservers of %s: %v", zone, err)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s has no NS records", zone)
+	}
+
+	var servers []string
+	for _, host := range hosts {
+		addrs, err := c.lookup(ctx, resolver, host, dns.TypeA)
+		if err == nil && len(addrs) == 0 {
+			addrs, err = c.lookup(ctx, resolver, host, dns.TypeAAAA)
+		}
+		if err != nil || len(addrs) == 0 {
+			return nil, fmt.Errorf("failed to resolve nameserver %s: %v", host, err)
+		}
+		for _, addr := range addrs {
+			servers = append(servers, net.JoinHostPort(addr, port))
+		}
+	}
+	return servers, nil
+}
+
+// lookup asks the resolver for NS host names or A/AAAA addresses. Without
+// a resolver, the system resolver is used.
+func (c *Checker) lookup(ctx context.Context, resolver, name string, qtype uint16) ([]string, error) {
+	if resolver == "" {
+		return systemLookup(ctx, name, qtype)
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	in, err := c.exchange(ctx, "udp", msg, resolver)
+	if err == nil && in.Truncated {
+		in, err = c.exchange(ctx, "tcp", msg, resolver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, rr := range in.Answer {
+		switch rr := rr.(type) {
+		case *dns.NS:
+			values = append(values, rr.Ns)
+		case *dns.A:
+			values = append(values, rr.A.String())
+		case *dns.AAAA:
+			values = append(values, rr.AAAA.String())
+		}
+	}
+	return values, nil
+}
+
+// systemLookup is lookup through net.DefaultResolver, for systems without
+// /etc/resolv.conf. Names that don't exist have no values.
+func systemLookup(ctx context.Context, name string, qtype uint16) ([]string, error) {
+	var values []string
+	var err error
+	switch qtype {
+	case dns.TypeNS:
+		var nss []*net.NS
+		nss, err = net.DefaultResolver.LookupNS(ctx, name)
+		for _, ns := range nss {
+			values = append(values, ns.Host)
+		}
+	case dns.TypeA, dns.TypeAAAA:
+		network := "ip4"
+		if qtype == dns.TypeAAAA {
+			network = "ip6"
+		}
+		var ips []net.IP
+		ips, err = net.DefaultResolver.LookupIP(ctx, network, name)
+		for _, ip := range ips {
+			values = append(values, ip.String())
+		}
+	}
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		err = nil
+	}
+	return values, err
+}
+
+// rdata returns the presentation form of rr's data. TXT strings are joined
+// into one text, as libdns represents them.
+func rdata(rr dns.RR) string {
+	if txt, ok := rr.(*dns.TXT); ok {
+		return strings.Join(txt.Txt, "")
+	}
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// normalizeData makes record data comparable: TXT data is compared as is,
+// other data case-insensitively, with whitespace collapsed and the
+// trailing dots of host names removed.
+func normalizeData(typ, data string) string {
+	if strings.EqualFold(typ, "TXT") {
+		return data
+	}
+	fields := strings.Fields(strings.ToLower(data))
+	for i, f := range fields {
+		fields[i] = strings.TrimSuffix(f, ".")
+	}
+	return strings.Join(fields, " ")
+}
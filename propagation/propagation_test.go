@@ -0,0 +1,108 @@
+package propagation
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+// serve starts an authoritative DNS server on 127.0.0.1 answering from
+// records (in zone file format) and returns its address.
+func serve(t *testing.T, records ...string) string {
+	t.Helper()
+	var rrs []dns.RR
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		q := r.Question[0]
+		for _, rr := range rrs {
+			if strings.EqualFold(rr.Header().Name, q.Name) && rr.Header().Rrtype == q.Qtype {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+var challenge = []libdns.RR{{Name: "_acme-challenge", Type: "TXT", Data: "token-a"}}
+
+func TestWait(t *testing.T) {
+	ns := serve(t, `_acme-challenge.example.com. 60 IN TXT "token-a"`)
+	c := &Checker{Nameservers: []string{ns}, Timeout: 2 * time.Second, Interval: 10 * time.Millisecond}
+
+	results, err := c.Wait(context.Background(), "example.com", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].OK() || results[0].Nameserver != ns {
+		t.Errorf("results %v, want %s ok", results, ns)
+	}
+}
+
+func TestWaitLagging(t *testing.T) {
+	upToDate := serve(t, `_acme-challenge.example.com. 60 IN TXT "token-a"`, `_acme-challenge.example.com. 60 IN TXT "token-b"`)
+	lagging := serve(t, `_acme-challenge.example.com. 60 IN TXT "token-a"`)
+	c := &Checker{Nameservers: []string{upToDate, lagging}, Timeout: 200 * time.Millisecond, Interval: 10 * time.Millisecond}
+
+	_, err := c.WaitTXT(context.Background(), "example.com", "_acme-challenge.example.com", "token-a", "token-b")
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("error %v, want an *Error", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want it to wrap context.DeadlineExceeded", err)
+	}
+	if len(perr.Lagging) != 1 || perr.Lagging[0].Nameserver != lagging {
+		t.Fatalf("lagging %v, want only %s", perr.Lagging, lagging)
+	}
+	if missing := perr.Lagging[0].Missing; len(missing) != 1 || missing[0].Data != "token-b" {
+		t.Errorf("missing %v, want token-b", missing)
+	}
+}
+
+func TestNameserversFromNS(t *testing.T) {
+	// The server is the resolver as well as the zone's only nameserver.
+	addr := serve(t,
+		`example.com. 60 IN NS ns1.example.com.`,
+		`ns1.example.com. 60 IN A 127.0.0.1`,
+		`_acme-challenge.example.com. 60 IN TXT "token-a"`,
+	)
+	_, port, _ := net.SplitHostPort(addr)
+	c := &Checker{Resolver: addr, Port: port, Timeout: 2 * time.Second, Interval: 10 * time.Millisecond}
+
+	servers, err := c.AuthoritativeNameservers(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0] != addr {
+		t.Fatalf("nameservers %v, want [%s]", servers, addr)
+	}
+	if _, err := c.Wait(context.Background(), "example.com", challenge); err != nil {
+		t.Fatal(err)
+	}
+}
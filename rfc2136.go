@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/libdns/websupport/websupport"
+)
+
+// serveRFC2136 runs a DNS server that accepts RFC 2136 dynamic updates
+// signed with TSIG and applies them through the Websupport API. It also
+// answers SOA, AXFR and plain queries for the zone from GetRecords, so that
+// tools like certbot-dns-rfc2136, ISC DHCP or external-dns' rfc2136 mode
+// work unmodified.
+func serveRFC2136(args []string) {
+	fs := flag.NewFlagSet("serve rfc2136", flag.ExitOnError)
+	listen := fs.String("listen", ":53", "address to listen on (UDP and TCP)")
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "zone to serve")
+	var keys stringList
+	fs.Var(&keys, "tsig-key", "TSIG key as [algorithm:]name:base64secret, like dig -y (repeatable; default algorithm hmac-sha256)")
+	cacheTTL := fs.Duration("cache", 10*time.Second, "how long queries are answered from a cached copy of the zone (0 disables)")
+	fs.Parse(args)
+
+	if *zoneName == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_TEST_ZONE must be set")
+	}
+	if len(keys) == 0 {
+		log.Fatal("Error: at least one -tsig-key is required")
+	}
+
+	secrets := make(map[string]string)
+	algorithms := make(map[string]string)
+	for _, k := range keys {
+		alg, name, secret, err := parseTSIGKey(k)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		secrets[name] = secret
+		algorithms[name] = alg
+	}
+
+	g := &rfc2136Gateway{
+		provider:   newProviderFromEnv(""),
+		zone:       dns.Fqdn(strings.ToLower(*zoneName)),
+		secrets:    secrets,
+		algorithms: algorithms,
+		cacheTTL:   *cacheTTL,
+	}
+
+	errc := make(chan error, 2)
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: *listen, Net: network, Handler: g, TsigSecret: secrets, MsgAcceptFunc: acceptUpdates}
+		go func() { errc <- srv.ListenAndServe() }()
+	}
+	log.Printf("🌐 Serving RFC 2136 updates for %s on %s (udp/tcp)\n", g.zone, *listen)
+	log.Fatal(<-errc)
+}
+
+// parseTSIGKey parses a key in dig's -y format: [algorithm:]name:secret.
+func parseTSIGKey(s string) (alg, name, secret string, err error) {
+	parts := strings.Split(s, ":")
+	switch len(parts) {
+	case 2:
+		alg, name, secret = "hmac-sha256", parts[0], parts[1]
+	case 3:
+		alg, name, secret = parts[0], parts[1], parts[2]
+	default:
+		return "", "", "", fmt.Errorf("invalid TSIG key %q, expected [algorithm:]name:secret", s)
+	}
+	algorithms := map[string]string{
+		"hmac-sha1":   dns.HmacSHA1,
+		"hmac-sha224": dns.HmacSHA224,
+		"hmac-sha256": dns.HmacSHA256,
+		"hmac-sha384": dns.HmacSHA384,
+		"hmac-sha512": dns.HmacSHA512,
+	}
+	fqdnAlg, ok := algorithms[strings.ToLower(strings.TrimSuffix(alg, "."))]
+	if !ok {
+		return "", "", "", fmt.Errorf("unsupported TSIG algorithm %q", alg)
+	}
+	return fqdnAlg, dns.Fqdn(strings.ToLower(name)), secret, nil
+}
+
+// acceptUpdates is dns.DefaultMsgAcceptFunc without its restrictions on
+// UPDATE messages, whose sections can carry any number of records.
+func acceptUpdates(dh dns.Header) dns.MsgAcceptAction {
+	if dh.Bits&(1<<15) != 0 { // QR: a response
+		return dns.MsgIgnore
+	}
+	if opcode := int(dh.Bits>>11) & 0xF; opcode == dns.OpcodeUpdate {
+		if dh.Qdcount != 1 {
+			return dns.MsgReject
+		}
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// rfc2136Gateway is the dns.Handler of "serve rfc2136".
+type rfc2136Gateway struct {
+	provider   *websupport.Provider
+	zone       string // fully qualified, lowercase
+	secrets    map[string]string
+	algorithms map[string]string
+
+	// cacheTTL is how long queries and transfers are answered from the
+	// zone's records as last listed, so that anonymous queries don't use
+	// up the API rate limit. Zero lists the records for every query.
+	cacheTTL time.Duration
+
+	mu         sync.Mutex
+	cached     []libdns.Record
+	cachedAt   time.Time
+	generation int // incremented by updates, to discard listings they overtook
+}
+
+// records returns the zone's records for queries and transfers, from the
+// cache if it is younger than cacheTTL.
+func (g *rfc2136Gateway) records(ctx context.Context) ([]libdns.Record, error) {
+	g.mu.Lock()
+	if g.cached != nil && time.Since(g.cachedAt) < g.cacheTTL {
+		defer g.mu.Unlock()
+		return g.cached, nil
+	}
+	generation := g.generation
+	g.mu.Unlock()
+
+	recs, err := g.provider.GetRecords(ctx, g.zone)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cacheTTL > 0 && g.generation == generation {
+		g.cached, g.cachedAt = recs, time.Now()
+	}
+	return recs, nil
+}
+
+// invalidate drops the cached records after the zone was changed.
+func (g *rfc2136Gateway) invalidate() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cached = nil
+	g.generation++
+}
+
+// ServeDNS dispatches updates and queries. Updates and zone transfers must
+// be signed with one of the configured TSIG keys.
+func (g *rfc2136Gateway) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	// dns.Server verifies the signature with the secret of the key's name,
+	// whatever algorithm the message names; the key must also be used with
+	// the algorithm it was configured with.
+	tsig := r.IsTsig()
+	signed := tsig != nil && w.TsigStatus() == nil &&
+		strings.EqualFold(tsig.Algorithm, g.algorithms[strings.ToLower(tsig.Hdr.Name)])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	switch {
+	case len(r.Question) != 1:
+		m.Rcode = dns.RcodeFormatError
+	case r.Opcode == dns.OpcodeUpdate:
+		if !signed {
+			log.Printf("⚠️  Refused unsigned or badly signed update from %s\n", w.RemoteAddr())
+			m.Rcode = dns.RcodeRefused
+			break
+		}
+		m.Rcode = g.update(ctx, r)
+	case r.Opcode != dns.OpcodeQuery:
+		m.Rcode = dns.RcodeNotImplemented
+	case r.Question[0].Qtype == dns.TypeAXFR:
+		if !signed {
+			m.Rcode = dns.RcodeRefused
+			break
+		}
+		if g.transfer(ctx, w, r, tsig) {
+			return
+		}
+		m.Rcode = dns.RcodeServerFailure
+	default:
+		g.query(ctx, m, r.Question[0])
+	}
+
+	if signed {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+	w.WriteMsg(m)
+}
+
+// update applies an UPDATE message (RFC 2136 §3): the zone section must
+// name the served zone and all prerequisites must hold. The update section
+// is then applied in order to a working copy of the zone (§3.4.2), and the
+// RRsets that differ from the zone in the end are replaced in one
+// transaction. Deleting an RRset and adding one of its records again thus
+// keeps the record, and re-adding a record with another TTL changes its
+// TTL.
+func (g *rfc2136Gateway) update(ctx context.Context, r *dns.Msg) int {
+	if q := r.Question[0]; !strings.EqualFold(q.Name, g.zone) || q.Qtype != dns.TypeSOA {
+		return dns.RcodeNotAuth
+	}
+
+	current, err := g.provider.GetRecords(ctx, g.zone)
+	if err != nil {
+		log.Printf("❌ Failed to get records: %v\n", err)
+		return dns.RcodeServerFailure
+	}
+
+	if rcode := g.checkPrerequisites(r.Answer, current); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	working := make([]libdns.RR, len(current))
+	for i, cur := range current {
+		working[i] = cur.RR()
+	}
+	for _, rr := range r.Ns {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(g.zone, strings.ToLower(hdr.Name)) {
+			return dns.RcodeNotZone
+		}
+		name := libdns.RelativeName(strings.ToLower(hdr.Name), g.zone)
+		typ := dns.TypeToString[hdr.Rrtype]
+
+		switch hdr.Class {
+		case dns.ClassINET:
+			// Add to an RRset; a record that exists already gets the
+			// TTL of the update. The SOA is synthesized and can't be
+			// changed.
+			if hdr.Rrtype == dns.TypeSOA {
+				continue
+			}
+			rec := fromDNSRR(rr, g.zone)
+			if i := indexRecord(working, rec); i >= 0 {
+				working[i] = rec
+			} else {
+				working = append(working, rec)
+			}
+		case dns.ClassANY:
+			// Delete an RRset, or all RRsets of a name. SOA and apex NS
+			// are never deleted.
+			working = slices.DeleteFunc(working, func(cur libdns.RR) bool {
+				return cur.Name == name && (hdr.Rrtype == dns.TypeANY || cur.Type == typ) && !g.isApexNS(cur)
+			})
+		case dns.ClassNONE:
+			// Delete a single RR
+			if i := indexRecord(working, fromDNSRR(rr, g.zone)); i >= 0 && !g.isApexNS(working[i]) {
+				working = slices.Delete(working, i, i+1)
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	tx := g.provider.Begin(g.zone)
+	for _, key := range changedRRsets(current, working) {
+		var set []libdns.Record
+		for _, rec := range working {
+			if rec.Name == key.Name && rec.Type == key.Type {
+				set = append(set, rec)
+			}
+		}
+		if len(set) > 0 {
+			tx.Set(set...)
+			continue
+		}
+		for _, cur := range current {
+			if rr := cur.RR(); rr.Name == key.Name && rr.Type == key.Type {
+				tx.Delete(cur)
+			}
+		}
+	}
+	result, err := tx.Commit(ctx)
+	g.invalidate()
+	if err != nil {
+		log.Printf("❌ Failed to update records: %v\n", err)
+		return dns.RcodeServerFailure
+	}
+	log.Printf("✅ Update signed by %s: %d deleted, %d updated, %d added\n", r.IsTsig().Hdr.Name,
+		len(result.Deleted), len(result.Updated), len(result.Created))
+	return dns.RcodeSuccess
+}
+
+// changedRRsets returns the name and type of the RRsets that differ
+// between the records of current and working, in data or TTL.
+func changedRRsets(current []libdns.Record, working []libdns.RR) []libdns.RR {
+	var keys []libdns.RR
+	add := func(rr libdns.RR) {
+		key := libdns.RR{Name: rr.Name, Type: rr.Type}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	for _, cur := range current {
+		rr := cur.RR()
+		if i := indexRecord(working, rr); i < 0 || working[i].TTL != rr.TTL {
+			add(rr)
+		}
+	}
+	for _, rr := range working {
+		if findRecord(current, rr) == nil {
+			add(rr)
+		}
+	}
+	return keys
+}
+
+// checkPrerequisites evaluates the prerequisite section (RFC 2136 §2.4).
+func (g *rfc2136Gateway) checkPrerequisites(prereqs []dns.RR, current []libdns.Record) int {
+	// Value-dependent RRset prerequisites are collected per name and type
+	// and compared as a whole.
+	wantSets := make(map[string][]libdns.Record)
+
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(g.zone, strings.ToLower(hdr.Name)) {
+			return dns.RcodeNotZone
+		}
+		name := libdns.RelativeName(strings.ToLower(hdr.Name), g.zone)
+		typ := dns.TypeToString[hdr.Rrtype]
+
+		exists := false
+		for _, cur := range current {
+			c := cur.RR()
+			if c.Name == name && (hdr.Rrtype == dns.TypeANY || c.Type == typ) {
+				exists = true
+				break
+			}
+		}
+
+		switch hdr.Class {
+		case dns.ClassANY:
+			// Name is in use / RRset exists (value independent)
+			if !exists {
+				if hdr.Rrtype == dns.TypeANY {
+					return dns.RcodeNameError
+				}
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			// Name is not in use / RRset does not exist
+			if exists {
+				if hdr.Rrtype == dns.TypeANY {
+					return dns.RcodeYXDomain
+				}
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			key := name + " " + typ
+			wantSets[key] = append(wantSets[key], fromDNSRR(rr, g.zone))
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	// RRset exists (value dependent): the RRsets must match exactly
+	for key, want := range wantSets {
+		var have []libdns.Record
+		for _, cur := range current {
+			if c := cur.RR(); c.Name+" "+c.Type == key {
+				have = append(have, cur)
+			}
+		}
+		if len(have) != len(want) {
+			return dns.RcodeNXRrset
+		}
+		for _, w := range want {
+			if findRecord(have, w) == nil {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+func (g *rfc2136Gateway) isApexNS(rr libdns.RR) bool {
+	return rr.Name == "@" && (rr.Type == "NS" || rr.Type == "SOA")
+}
+
+// query answers a regular query for the zone from its records.
+func (g *rfc2136Gateway) query(ctx context.Context, m *dns.Msg, q dns.Question) {
+	qname := strings.ToLower(q.Name)
+	if !dns.IsSubDomain(g.zone, qname) {
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+
+	current, err := g.records(ctx)
+	if err != nil {
+		log.Printf("❌ Failed to get records: %v\n", err)
+		m.Rcode = dns.RcodeServerFailure
+		return
+	}
+	soa := g.soa(current)
+
+	if qname == g.zone && q.Qtype == dns.TypeSOA {
+		m.Answer = append(m.Answer, soa)
+		return
+	}
+
+	name := libdns.RelativeName(qname, g.zone)
+	found := qname == g.zone
+	for _, cur := range current {
+		rr := cur.RR()
+		if rr.Name != name {
+			continue
+		}
+		found = true
+		if q.Qtype == dns.TypeANY || rr.Type == dns.TypeToString[q.Qtype] {
+			if drr, err := toDNSRR(rr, g.zone); err == nil {
+				m.Answer = append(m.Answer, drr)
+			}
+		}
+	}
+	if !found {
+		m.Rcode = dns.RcodeNameError
+	}
+	if len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, soa)
+	}
+}
+
+// transfer answers an AXFR with the SOA, all records and the SOA again.
+// It reports whether the transfer was started.
+func (g *rfc2136Gateway) transfer(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, tsig *dns.TSIG) bool {
+	if !strings.EqualFold(r.Question[0].Name, g.zone) {
+		return false
+	}
+	current, err := g.records(ctx)
+	if err != nil {
+		log.Printf("❌ Failed to get records: %v\n", err)
+		return false
+	}
+
+	soa := g.soa(current)
+	rrs := []dns.RR{soa}
+	for _, cur := range current {
+		if rr := cur.RR(); rr.Type != "SOA" {
+			if drr, err := toDNSRR(rr, g.zone); err == nil {
+				rrs = append(rrs, drr)
+			}
+		}
+	}
+	rrs = append(rrs, soa)
+
+	tr := &dns.Transfer{TsigSecret: g.secrets}
+	ch := make(chan *dns.Envelope, 1)
+	ch <- &dns.Envelope{RR: rrs}
+	close(ch)
+	if err := tr.Out(w, r, ch); err != nil {
+		log.Printf("❌ Zone transfer failed: %v\n", err)
+		return true
+	}
+	log.Printf("✅ Zone transfer of %d records to %s\n", len(rrs)-2, w.RemoteAddr())
+	return true
+}
+
+// soa synthesizes the zone's SOA record, which the Websupport API does not
+// expose. The serial is derived from the record set, so it changes
+// whenever the zone does.
+func (g *rfc2136Gateway) soa(current []libdns.Record) *dns.SOA {
+	mname := "ns1.websupport.sk."
+	var lines []string
+	for _, cur := range current {
+		rr := cur.RR()
+		lines = append(lines, fmt.Sprintf("%s %s %d %s", rr.Name, rr.Type, int(rr.TTL.Seconds()), rr.Data))
+		if rr.Name == "@" && rr.Type == "NS" && mname == "ns1.websupport.sk." {
+			mname = dns.Fqdn(rr.Data)
+		}
+	}
+	sort.Strings(lines)
+	h := fnv.New32a()
+	for _, l := range lines {
+		h.Write([]byte(l + "\n"))
+	}
+
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: g.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 600},
+		Ns:      mname,
+		Mbox:    "hostmaster." + g.zone,
+		Serial:  h.Sum32(),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		Minttl:  600,
+	}
+}
+
+// fromDNSRR converts a resource record into a libdns record relative to
+// zone. TXT strings are joined into one text.
+func fromDNSRR(rr dns.RR, zone string) libdns.RR {
+	hdr := rr.Header()
+	data := strings.TrimPrefix(rr.String(), hdr.String())
+	if txt, ok := rr.(*dns.TXT); ok {
+		data = strings.Join(txt.Txt, "")
+	}
+	return libdns.RR{
+		Name: libdns.RelativeName(strings.ToLower(hdr.Name), zone),
+		Type: dns.TypeToString[hdr.Rrtype],
+		TTL:  time.Duration(hdr.Ttl) * time.Second,
+		Data: data,
+	}
+}
+
+// toDNSRR converts a libdns record of zone into a resource record. Host
+// names in the data are taken to be absolute, as Websupport stores them.
+func toDNSRR(rr libdns.RR, zone string) (dns.RR, error) {
+	hdr := dns.RR_Header{
+		Name:   libdns.AbsoluteName(rr.Name, zone),
+		Class:  dns.ClassINET,
+		Ttl:    uint32(rr.TTL.Seconds()),
+		Rrtype: dns.StringToType[rr.Type],
+	}
+	if rr.Type == "TXT" {
+		txt := &dns.TXT{Hdr: hdr}
+		for data := rr.Data; data != "" || len(txt.Txt) == 0; {
+			n := min(len(data), 255)
+			txt.Txt = append(txt.Txt, data[:n])
+			data = data[n:]
+		}
+		return txt, nil
+	}
+
+	data := rr.Data
+	switch rr.Type {
+	case "CNAME", "NS", "PTR", "MX", "SRV":
+		data = dns.Fqdn(data)
+	}
+	return dns.NewRR(fmt.Sprintf("%s %d IN %s %s", hdr.Name, hdr.Ttl, rr.Type, data))
+}
+
+// indexRecord returns the index of the record of recs with the same name,
+// type and data as want, ignoring TTL, or -1.
+func indexRecord(recs []libdns.RR, want libdns.RR) int {
+	return slices.IndexFunc(recs, func(r libdns.RR) bool { return sameRecord(r, want) })
+}
+
+// findRecord returns the record of recs with the same name, type and data
+// as want, ignoring TTL.
+func findRecord(recs []libdns.Record, want libdns.Record) libdns.Record {
+	w := want.RR()
+	for _, rec := range recs {
+		if sameRecord(rec.RR(), w) {
+			return rec
+		}
+	}
+	return nil
+}
+
+// sameRecord reports whether a and b have the same name, type and data,
+// ignoring TTL and trailing dots.
+func sameRecord(a, b libdns.RR) bool {
+	return a.Name == b.Name && a.Type == b.Type && strings.TrimSuffix(a.Data, ".") == strings.TrimSuffix(b.Data, ".")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+	"github.com/libdns/websupport/websupport"
+)
+
+const testTSIGKey, testTSIGSecret = "update.", "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA=="
+
+// testGateway serves an rfc2136 gateway for example.com, backed by an
+// in-memory Websupport API holding records, and returns its address. lists
+// counts the record listings the gateway made.
+func testGateway(t *testing.T, cacheTTL time.Duration, records ...fakeapi.Record) (addr string, api *fakeapi.API, lists *atomic.Int32) {
+	t.Helper()
+	api = fakeapi.New(records...)
+	lists = new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			lists.Add(1)
+		}
+		api.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	g := &rfc2136Gateway{
+		provider:   &websupport.Provider{APIKey: "key", APISecret: "secret", ServiceID: "1", APIBase: srv.URL},
+		zone:       "example.com.",
+		secrets:    map[string]string{testTSIGKey: testTSIGSecret},
+		algorithms: map[string]string{testTSIGKey: dns.HmacSHA256},
+		cacheTTL:   cacheTTL,
+	}
+	return serveDNS(t, g, g.secrets), api, lists
+}
+
+// exchange sends m to addr over TCP, signed with secret unless it is "",
+// and returns the reply.
+func exchange(t *testing.T, addr string, m *dns.Msg, secret string) *dns.Msg {
+	t.Helper()
+	c := &dns.Client{Net: "tcp"}
+	if secret != "" {
+		c.TsigSecret = map[string]string{testTSIGKey: secret}
+		m.SetTsig(testTSIGKey, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	reply, _, err := c.Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// newUpdate returns an UPDATE of example.com that deletes the RRsets of
+// removeRRset, adds insert and deletes remove, in that order.
+func newUpdate(t *testing.T, insert, remove, removeRRset []string) *dns.Msg {
+	t.Helper()
+	rrs := func(records []string) []dns.RR {
+		var rrs []dns.RR
+		for _, s := range records {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rrs = append(rrs, rr)
+		}
+		return rrs
+	}
+	m := new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.RemoveRRset(rrs(removeRRset))
+	m.Insert(rrs(insert))
+	m.Remove(rrs(remove))
+	return m
+}
+
+var wwwA = fakeapi.Record{Name: "www", Type: "A", Content: "1.1.1.1", TTL: 600}
+
+func TestRFC2136RefusesUnsignedUpdates(t *testing.T) {
+	addr, api, _ := testGateway(t, 0, wwwA)
+	insert := []string{"new.example.com. 600 IN A 2.2.2.2"}
+
+	if reply := exchange(t, addr, newUpdate(t, insert, nil, nil), ""); reply.Rcode != dns.RcodeRefused {
+		t.Errorf("unsigned update: %s, want REFUSED", dns.RcodeToString[reply.Rcode])
+	}
+	m := newUpdate(t, insert, nil, nil)
+	m.SetTsig("unknown.", dns.HmacSHA256, 300, time.Now().Unix())
+	c := &dns.Client{Net: "tcp", TsigSecret: map[string]string{"unknown.": testTSIGSecret}}
+	if reply, _, err := c.Exchange(m, addr); err == nil && reply.Rcode == dns.RcodeSuccess {
+		t.Error("update signed with an unknown key succeeded")
+	}
+	if reqs := api.Requests(); len(reqs) != 0 {
+		t.Errorf("refused updates sent %v", reqs)
+	}
+}
+
+func TestRFC2136UpdateInOrder(t *testing.T) {
+	for _, tt := range []struct {
+		name                        string
+		insert, remove, removeRRset []string
+		want                        []string
+		requests                    int
+	}{
+		{
+			name:        "delete RRset and add its record again",
+			removeRRset: []string{"www.example.com. 0 IN A 0.0.0.0"},
+			insert:      []string{"www.example.com. 600 IN A 1.1.1.1"},
+			want:        []string{"1001 www A 1.1.1.1 600"},
+		},
+		{
+			name:   "add then delete",
+			insert: []string{"new.example.com. 600 IN A 2.2.2.2"},
+			remove: []string{"new.example.com. 0 IN A 2.2.2.2"},
+			want:   []string{"1001 www A 1.1.1.1 600"},
+		},
+		{
+			name:     "re-add with another TTL",
+			insert:   []string{"www.example.com. 300 IN A 1.1.1.1"},
+			want:     []string{"1001 www A 1.1.1.1 300"},
+			requests: 1,
+		},
+		{
+			name:        "replace RRset",
+			removeRRset: []string{"www.example.com. 0 IN A 0.0.0.0"},
+			insert:      []string{"www.example.com. 600 IN A 3.3.3.3"},
+			want:        []string{"1001 www A 3.3.3.3 600"},
+			requests:    1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, api, _ := testGateway(t, 0, wwwA)
+			reply := exchange(t, addr, newUpdate(t, tt.insert, tt.remove, tt.removeRRset), testTSIGSecret)
+			if reply.Rcode != dns.RcodeSuccess {
+				t.Fatalf("update: %s", dns.RcodeToString[reply.Rcode])
+			}
+			var got []string
+			for _, rec := range api.Records() {
+				got = append(got, fmt.Sprintf("%d %s %s %s %d", rec.ID, rec.Name, rec.Type, rec.Content, rec.TTL))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("zone %v, want %v", got, tt.want)
+			}
+			if reqs := api.Requests(); len(reqs) != tt.requests {
+				t.Errorf("requests %v, want %d", reqs, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRFC2136Prerequisites(t *testing.T) {
+	addr, api, _ := testGateway(t, 0, wwwA)
+	m := newUpdate(t, []string{"new.example.com. 600 IN A 2.2.2.2"}, nil, nil)
+	m.NameUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "missing.example.com."}}})
+
+	if reply := exchange(t, addr, m, testTSIGSecret); reply.Rcode != dns.RcodeNameError {
+		t.Errorf("update: %s, want NXDOMAIN", dns.RcodeToString[reply.Rcode])
+	}
+	if reqs := api.Requests(); len(reqs) != 0 {
+		t.Errorf("failed prerequisite sent %v", reqs)
+	}
+}
+
+func TestRFC2136QueryCache(t *testing.T) {
+	addr, _, lists := testGateway(t, time.Minute, wwwA)
+	query := func() []dns.RR {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		return exchange(t, addr, m, "").Answer
+	}
+
+	for range 3 {
+		if answer := query(); len(answer) != 1 || answer[0].(*dns.A).A.String() != "1.1.1.1" {
+			t.Fatalf("answer %v, want 1.1.1.1", answer)
+		}
+	}
+	if n := lists.Load(); n != 1 {
+		t.Errorf("%d listings for 3 queries, want 1", n)
+	}
+
+	update := newUpdate(t, []string{"www.example.com. 600 IN A 3.3.3.3"}, nil, []string{"www.example.com. 0 IN A 0.0.0.0"})
+	if reply := exchange(t, addr, update, testTSIGSecret); reply.Rcode != dns.RcodeSuccess {
+		t.Fatalf("update: %s", dns.RcodeToString[reply.Rcode])
+	}
+	if answer := query(); len(answer) != 1 || answer[0].(*dns.A).A.String() != "3.3.3.3" {
+		t.Errorf("answer %v after the update, want 3.3.3.3", answer)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runServe dispatches the "serve" subcommands, which run protocol gateways
+// in front of the Websupport API.
+func runServe(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: libdns-websupport serve <rfc2136|acme-dns|dyndns|httpreq> [flags]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "rfc2136":
+		serveRFC2136(args[1:])
+	case "acme-dns":
+		serveACMEDNS(args[1:])
+	case "dyndns":
+		serveDynDNS(args[1:])
+	case "httpreq":
+		serveHTTPReq(args[1:])
+	default:
+		fmt.Printf("Unknown serve command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// stringList is a flag.Value collecting repeated string flags.
+type stringList []string
+
+func (l *stringList) String() string     { return fmt.Sprint(*l) }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
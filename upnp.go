@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// upnpServices are the UPnP IGD services that can report the WAN address.
+var upnpServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpExternalAddr asks the local UPnP Internet Gateway Device for its
+// external IPv4 address: the gateway is discovered with SSDP, its device
+// description gives the control URL of the WAN connection service, and
+// GetExternalIPAddress is called over SOAP.
+func upnpExternalAddr(ctx context.Context) (netip.Addr, error) {
+	location, service, err := upnpDiscover(ctx)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	controlURL, err := upnpControlURL(ctx, location, service)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + service + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+`#GetExternalIPAddress"`)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("GetExternalIPAddress: unexpected status %s", resp.Status)
+	}
+
+	var envelope struct {
+		Body struct {
+			Response struct {
+				ExternalIPAddress string `xml:"NewExternalIPAddress"`
+			} `xml:"GetExternalIPAddressResponse"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return netip.Addr{}, fmt.Errorf("GetExternalIPAddress: %v", err)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(envelope.Body.Response.ExternalIPAddress))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("GetExternalIPAddress: %v", err)
+	}
+	return addr, nil
+}
+
+// upnpDiscover sends an SSDP M-SEARCH for each of upnpServices and returns
+// the description URL and service type of the first gateway that answers.
+func upnpDiscover(ctx context.Context) (location, service string, err error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+
+	ssdp := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	for _, st := range upnpServices {
+		msg := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: 239.255.255.250:1900\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n" +
+			"ST: " + st + "\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(msg), ssdp); err != nil {
+			return "", "", err
+		}
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", "", fmt.Errorf("no UPnP gateway found: %v", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		st, loc := resp.Header.Get("ST"), resp.Header.Get("Location")
+		for _, s := range upnpServices {
+			if st == s && loc != "" {
+				return loc, st, nil
+			}
+		}
+	}
+}
+
+// upnpControlURL reads the device description at location and returns the
+// absolute control URL of service.
+func upnpControlURL(ctx context.Context, location, service string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	// Services are nested in devices at varying depths, so the description
+	// is scanned for service elements rather than decoded as a tree.
+	type upnpService struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	}
+	var baseURL string
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "URLBase":
+			dec.DecodeElement(&baseURL, &start)
+		case "service":
+			var s upnpService
+			if dec.DecodeElement(&s, &start) == nil && s.ServiceType == service {
+				if baseURL == "" {
+					baseURL = location
+				}
+				base, err := url.Parse(strings.TrimSpace(baseURL))
+				if err != nil {
+					return "", err
+				}
+				ref, err := url.Parse(strings.TrimSpace(s.ControlURL))
+				if err != nil {
+					return "", err
+				}
+				return base.ResolveReference(ref).String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("gateway at %s does not offer %s", location, service)
+}
@@ -0,0 +1,110 @@
+package websupport
+
+import (
+	"context"
+
+	"github.com/libdns/libdns"
+)
+
+// ACMEOnlyProvider restricts a Provider to _acme-challenge TXT records.
+// Other records are refused by AppendRecords, SetRecords and DeleteRecords
+// with a *ProtectedRecordError and left out of GetRecords, so credentials
+// handed to an ACME client can't be used to read or hijack the rest of the
+// zone through it.
+type ACMEOnlyProvider struct {
+	provider *Provider
+	scope    *ProtectionPolicy
+}
+
+// ACMEOnly wraps p so that only _acme-challenge TXT records can be seen and
+// changed.
+func ACMEOnly(p *Provider) *ACMEOnlyProvider {
+	return &ACMEOnlyProvider{provider: p, scope: ACMEChallengeOnly()}
+}
+
+// inScope returns a *ProtectedRecordError unless rec is an ACME challenge
+// record.
+func (a *ACMEOnlyProvider) inScope(action, zone string, rec libdns.Record) error {
+	item := newAPIRecord(rec, zone)
+	if reason := a.scope.check(item); reason != "" {
+		return &ProtectedRecordError{Action: action, Zone: zone, Record: item.rr(), Reason: "outside the ACME challenge scope"}
+	}
+	return nil
+}
+
+// GetRecords returns the _acme-challenge TXT records of the zone.
+func (a *ACMEOnlyProvider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	recs, err := a.provider.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []libdns.Record
+	for _, rec := range recs {
+		if a.inScope("read", zone, rec) == nil {
+			filtered = append(filtered, rec)
+		}
+	}
+	return filtered, nil
+}
+
+// AppendRecords creates the records if all of them are _acme-challenge TXT
+// records.
+func (a *ACMEOnlyProvider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		if err := a.inScope("create", zone, rec); err != nil {
+			return nil, err
+		}
+	}
+	return a.provider.AppendRecords(ctx, zone, recs)
+}
+
+// DeleteRecords deletes the records if all of them are _acme-challenge TXT
+// records. Records carrying an ID must refer to such a record in the zone,
+// so a forged ID can't be used to delete anything else.
+func (a *ACMEOnlyProvider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var withID bool
+	for _, rec := range recs {
+		if err := a.inScope("delete", zone, rec); err != nil {
+			return nil, err
+		}
+		withID = withID || RecordID(rec) != ""
+	}
+
+	if withID {
+		visible, err := a.GetRecords(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+		ids := make(map[string]bool, len(visible))
+		for _, rec := range visible {
+			ids[RecordID(rec)] = true
+		}
+		for _, rec := range recs {
+			if id := RecordID(rec); id != "" && !ids[id] {
+				rr := newAPIRecord(rec, zone).rr()
+				return nil, &ProtectedRecordError{Action: "delete", Zone: zone, Record: rr, Reason: "record ID " + id + " is outside the ACME challenge scope"}
+			}
+		}
+	}
+	return a.provider.DeleteRecords(ctx, zone, recs)
+}
+
+// SetRecords replaces the RRsets of the records if all of them are
+// _acme-challenge TXT records. The RRsets replaced are those of the
+// records' names and type, so nothing outside the scope is changed.
+func (a *ACMEOnlyProvider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		if err := a.inScope("update", zone, rec); err != nil {
+			return nil, err
+		}
+	}
+	return a.provider.SetRecords(ctx, zone, recs)
+}
+
+// Interface guards
+var (
+	_ libdns.RecordGetter   = (*ACMEOnlyProvider)(nil)
+	_ libdns.RecordAppender = (*ACMEOnlyProvider)(nil)
+	_ libdns.RecordSetter   = (*ACMEOnlyProvider)(nil)
+	_ libdns.RecordDeleter  = (*ACMEOnlyProvider)(nil)
+)
@@ -0,0 +1,39 @@
+package websupport
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+func TestACMEOnlySetRecords(t *testing.T) {
+	api := fakeapi.New(
+		fakeapi.Record{Name: "_acme-challenge", Type: "TXT", Content: "old", TTL: 120},
+		fakeapi.Record{Name: "www", Type: "A", Content: "1.1.1.1", TTL: 600},
+	)
+	acme := ACMEOnly(testProvider(t, api))
+
+	_, err := acme.SetRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.Address{Name: "www", IP: netip.MustParseAddr("2.2.2.2")},
+	})
+	var perr *ProtectedRecordError
+	if !errors.As(err, &perr) {
+		t.Fatalf("error %v, want a *ProtectedRecordError", err)
+	}
+
+	if _, err := acme.SetRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "new"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"_acme-challenge TXT new 120", "www A 1.1.1.1 600"}
+	if got := zoneContents(api); !slices.Equal(got, want) {
+		t.Errorf("zone %v, want %v", got, want)
+	}
+}
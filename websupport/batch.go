@@ -0,0 +1,181 @@
+package websupport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// BatchError is returned by AppendRecords and DeleteRecords when some of
+// the records could not be created or deleted. errors.As and errors.Is see
+// through it to the errors of the individual records.
+type BatchError struct {
+	Action    string // "create" or "delete"
+	Zone      string
+	Succeeded []libdns.Record // records created or deleted, and not rolled back
+	Failed    []RecordError
+
+	// RolledBack are the records deleted again because of the failures,
+	// if Provider.BatchRollback is set. RollbackErr is why the rollback
+	// did not delete all of them.
+	RolledBack  []libdns.Record
+	RollbackErr error
+}
+
+// RecordError is the failure of one record of a batch.
+type RecordError struct {
+	Record libdns.RR
+	Err    error
+}
+
+func (e RecordError) Error() string {
+	return fmt.Sprintf("%s %s %q: %v", e.Record.Name, e.Record.Type, e.Record.Data, e.Err)
+}
+
+func (e RecordError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BatchError) Error() string {
+	total := len(e.Succeeded) + len(e.RolledBack) + len(e.Failed)
+	failed := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		failed[i] = f.Error()
+	}
+	msg := fmt.Sprintf("failed to %s %d of %d records in %s: %s",
+		e.Action, len(e.Failed), total, e.Zone, strings.Join(failed, "; "))
+	switch {
+	case e.RollbackErr != nil:
+		msg += fmt.Sprintf(" (rollback failed, %d records left in place: %v)", len(e.Succeeded), e.RollbackErr)
+	case len(e.RolledBack) > 0:
+		msg += fmt.Sprintf(" (rolled back %d created records)", len(e.RolledBack))
+	}
+	return msg
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f
+	}
+	return errs
+}
+
+// rollbackTimeout bounds undoing a failed batch or transaction, which
+// continues even if the context of the call has ended: a call that failed
+// because its context was cancelled must still remove what it created.
+const rollbackTimeout = 2 * time.Minute
+
+// errSkipped is the error of records not attempted because the batch was
+// going to be rolled back.
+var errSkipped = errors.New("not attempted after an earlier record failed")
+
+// runBatch calls fn for 0 to n-1 with at most BatchConcurrency calls
+// running at once, and returns their errors by index. With stopOnError,
+// no more calls are started after one has failed.
+func (p *Provider) runBatch(ctx context.Context, n int, stopOnError bool, fn func(i int) error) []error {
+	limit := p.BatchConcurrency
+	if limit <= 0 {
+		limit = 4
+	}
+	errs := make([]error, n)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for i := range n {
+		sem <- struct{}{}
+		if stopOnError && failed.Load() {
+			errs[i] = errSkipped
+			<-sem
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			<-sem
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(i); err != nil {
+				errs[i] = err
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// createRecord sends the request creating one record.
+func (p *Provider) createRecord(ctx context.Context, item apiRecord) error {
+	resp, err := p.doRequest(ctx, "POST", fmt.Sprintf("/service/%s/dns/record", p.ServiceID), item)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Websupport returns 204 No Content on success
+	if resp.StatusCode != 204 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to create record: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+	return nil
+}
+
+// deleteRecord sends the request deleting the record with the given ID.
+func (p *Provider) deleteRecord(ctx context.Context, id string) error {
+	resp, err := p.doRequest(ctx, "DELETE", fmt.Sprintf("/service/%s/dns/record/%s", p.ServiceID, id), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 204 {
+		return fmt.Errorf("failed to delete record: %s", resp.Status)
+	}
+	return nil
+}
+
+// updateRecordByID sends the request changing the record with the given
+// ID to item.
+func (p *Provider) updateRecordByID(ctx context.Context, id string, item apiRecord) error {
+	resp, err := p.doRequest(ctx, "PUT", fmt.Sprintf("/service/%s/dns/record/%s", p.ServiceID, id), item)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update record: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+	return nil
+}
+
+// withoutRecords returns recs without the records in remove, compared by
+// ID and content.
+func withoutRecords(recs, remove []libdns.Record) []libdns.Record {
+	var kept []libdns.Record
+	for _, rec := range recs {
+		found := false
+		for _, r := range remove {
+			if RecordID(r) == RecordID(rec) && r.RR() == rec.RR() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			kept = append(kept, rec)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,207 @@
+package websupport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+func TestRunBatchConcurrency(t *testing.T) {
+	p := &Provider{BatchConcurrency: 2}
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	var called []int
+	errs := p.runBatch(context.Background(), 10, false, func(i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		called = append(called, i)
+		mu.Unlock()
+		return nil
+	})
+
+	if peak.Load() != 2 {
+		t.Errorf("%d calls ran at once, want 2", peak.Load())
+	}
+	slices.Sort(called)
+	if !slices.Equal(called, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("called %v, want 0 to 9 once each", called)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("errs[%d] = %v", i, err)
+		}
+	}
+}
+
+func TestRunBatchStopOnError(t *testing.T) {
+	p := &Provider{BatchConcurrency: 1}
+	failure := errors.New("failure")
+	var called []int
+	errs := p.runBatch(context.Background(), 5, true, func(i int) error {
+		called = append(called, i)
+		if i == 2 {
+			return failure
+		}
+		return nil
+	})
+
+	if !slices.Equal(called, []int{0, 1, 2}) {
+		t.Errorf("called %v, want 0 to 2", called)
+	}
+	want := []error{nil, nil, failure, errSkipped, errSkipped}
+	if !slices.Equal(errs, want) {
+		t.Errorf("errs %v, want %v", errs, want)
+	}
+}
+
+func TestAppendRecordsBatchError(t *testing.T) {
+	api := fakeapi.New()
+	p := testProvider(t, failing(api, func(r *http.Request, rec fakeapi.Record) bool {
+		return rec.Content == "bad"
+	}))
+
+	created, err := p.AppendRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.TXT{Name: "a", Text: "good"},
+		libdns.TXT{Name: "b", Text: "bad"},
+		libdns.TXT{Name: "c", Text: "good"},
+	})
+
+	var berr *BatchError
+	if !errors.As(err, &berr) {
+		t.Fatalf("error %v, want a *BatchError", err)
+	}
+	if len(created) != 2 || len(berr.Succeeded) != 2 || len(berr.RolledBack) != 0 {
+		t.Errorf("created %v, succeeded %v, rolled back %v; want a and c created", created, berr.Succeeded, berr.RolledBack)
+	}
+	for _, rec := range berr.Succeeded {
+		if RecordID(rec) == "" {
+			t.Errorf("created record %v has no ID", rec)
+		}
+	}
+	if len(berr.Failed) != 1 || berr.Failed[0].Record.Name != "b" {
+		t.Errorf("failed %v, want b", berr.Failed)
+	}
+	var rerr RecordError
+	if !errors.As(err, &rerr) || rerr.Record.Name != "b" {
+		t.Errorf("errors.As found %v, want the RecordError of b", rerr)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "failed to create 1 of 3 records in example.com.: b TXT \"bad\": ") {
+		t.Errorf("message %q", msg)
+	}
+	if got := zoneContents(api); !slices.Equal(got, []string{"a TXT good 120", "c TXT good 120"}) {
+		t.Errorf("zone %v", got)
+	}
+}
+
+func TestAppendRecordsBatchRollback(t *testing.T) {
+	// An identical record exists already; the rollback must delete only
+	// the one the call created.
+	api := fakeapi.New(fakeapi.Record{Name: "a", Type: "TXT", Content: "good", TTL: 120})
+	p := testProvider(t, failing(api, func(r *http.Request, rec fakeapi.Record) bool {
+		return rec.Content == "bad"
+	}))
+	p.BatchRollback = true
+	p.BatchConcurrency = 1
+
+	created, err := p.AppendRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.TXT{Name: "a", Text: "good"},
+		libdns.TXT{Name: "b", Text: "bad"},
+		libdns.TXT{Name: "c", Text: "never sent"},
+	})
+
+	var berr *BatchError
+	if !errors.As(err, &berr) {
+		t.Fatalf("error %v, want a *BatchError", err)
+	}
+	if len(created) != 0 || len(berr.RolledBack) != 1 || berr.RollbackErr != nil {
+		t.Errorf("created %v, rolled back %v (%v); want a rolled back", created, berr.RolledBack, berr.RollbackErr)
+	}
+	if len(berr.Failed) != 2 || !errors.Is(berr.Failed[1].Err, errSkipped) {
+		t.Errorf("failed %v, want b and c skipped", berr.Failed)
+	}
+	if !strings.HasSuffix(err.Error(), "(rolled back 1 created records)") {
+		t.Errorf("message %q", err)
+	}
+	if recs := api.Records(); len(recs) != 1 || recs[0].ID != 1001 {
+		t.Errorf("zone %+v, want only the original record 1001", recs)
+	}
+}
+
+func TestAppendRecordsBatchRollbackAfterCancel(t *testing.T) {
+	api := fakeapi.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := testProvider(t, failing(api, func(r *http.Request, rec fakeapi.Record) bool {
+		if rec.Content == "bad" {
+			cancel()
+			return true
+		}
+		return false
+	}))
+	p.BatchRollback = true
+	p.BatchConcurrency = 1
+
+	_, err := p.AppendRecords(ctx, "example.com.", []libdns.Record{
+		libdns.TXT{Name: "a", Text: "good"},
+		libdns.TXT{Name: "b", Text: "bad"},
+	})
+
+	var berr *BatchError
+	if !errors.As(err, &berr) {
+		t.Fatalf("error %v, want a *BatchError", err)
+	}
+	if len(berr.RolledBack) != 1 || berr.RollbackErr != nil {
+		t.Errorf("rolled back %v (%v), want a", berr.RolledBack, berr.RollbackErr)
+	}
+	if recs := api.Records(); len(recs) != 0 {
+		t.Errorf("zone %+v, want it empty after the rollback", recs)
+	}
+}
+
+func TestDeleteRecordsBatchError(t *testing.T) {
+	api := fakeapi.New(
+		fakeapi.Record{Name: "a", Type: "A", Content: "1.1.1.1"},
+		fakeapi.Record{Name: "b", Type: "A", Content: "2.2.2.2"},
+	)
+	p := testProvider(t, failing(api, func(r *http.Request, rec fakeapi.Record) bool {
+		return r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/1002")
+	}))
+
+	deleted, err := p.DeleteRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.RR{Name: "a", Type: "A", Data: "1.1.1.1"},
+		libdns.RR{Name: "b", Type: "A", Data: "2.2.2.2"},
+		libdns.RR{Name: "missing", Type: "A", Data: "3.3.3.3"},
+	})
+
+	var berr *BatchError
+	if !errors.As(err, &berr) || berr.Action != "delete" {
+		t.Fatalf("error %v, want a *BatchError of delete", err)
+	}
+	if len(deleted) != 1 || deleted[0].RR().Name != "a" {
+		t.Errorf("deleted %v, want a", deleted)
+	}
+	if len(berr.Failed) != 1 || berr.Failed[0].Record.Name != "b" {
+		t.Errorf("failed %v, want b", berr.Failed)
+	}
+	if got := zoneContents(api); !slices.Equal(got, []string{"b A 2.2.2.2 0"}) {
+		t.Errorf("zone %v", got)
+	}
+}
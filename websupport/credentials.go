@@ -0,0 +1,178 @@
+package websupport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials are a Websupport API key and secret.
+type Credentials struct {
+	APIKey    string
+	APISecret string
+}
+
+// CredentialSource supplies the API credentials of a provider. The
+// provider asks for them before its first request and again whenever the
+// API answers 401 Unauthorized, so rotated secrets are picked up without a
+// restart.
+type CredentialSource interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// EnvCredentials reads the credentials from environment variables,
+// WEBSUPPORT_API_KEY and WEBSUPPORT_API_SECRET unless KeyVar and SecretVar
+// are set. If a variable is unset, the file named by the same variable
+// with a _FILE suffix is read instead, as is usual for Docker and
+// Kubernetes secrets.
+type EnvCredentials struct {
+	KeyVar    string
+	SecretVar string
+}
+
+// Credentials implements CredentialSource.
+func (e EnvCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	keyVar, secretVar := e.KeyVar, e.SecretVar
+	if keyVar == "" {
+		keyVar = "WEBSUPPORT_API_KEY"
+	}
+	if secretVar == "" {
+		secretVar = "WEBSUPPORT_API_SECRET"
+	}
+	key, err := lookupEnv(keyVar)
+	if err != nil {
+		return Credentials{}, err
+	}
+	secret, err := lookupEnv(secretVar)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{APIKey: key, APISecret: secret}, nil
+}
+
+// lookupEnv returns the value of the environment variable name, or the
+// contents of the file named by name_FILE.
+func lookupEnv(name string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+	if file := os.Getenv(name + "_FILE"); file != "" {
+		return readSecret(file)
+	}
+	return "", fmt.Errorf("neither %s nor %s_FILE is set", name, name)
+}
+
+// FileCredentials reads the credentials from two files, e.g. mounted
+// Kubernetes secrets.
+type FileCredentials struct {
+	KeyFile    string
+	SecretFile string
+}
+
+// Credentials implements CredentialSource.
+func (f FileCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	key, err := readSecret(f.KeyFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	secret, err := readSecret(f.SecretFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{APIKey: key, APISecret: secret}, nil
+}
+
+// SystemdCredentials reads the credentials passed to a systemd service
+// with LoadCredential= or LoadCredentialEncrypted=, from the directory in
+// $CREDENTIALS_DIRECTORY. The credential names default to
+// websupport-api-key and websupport-api-secret.
+type SystemdCredentials struct {
+	KeyName    string
+	SecretName string
+}
+
+// Credentials implements CredentialSource.
+func (s SystemdCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return Credentials{}, fmt.Errorf("CREDENTIALS_DIRECTORY is not set; is the service run with LoadCredential=?")
+	}
+	keyName, secretName := s.KeyName, s.SecretName
+	if keyName == "" {
+		keyName = "websupport-api-key"
+	}
+	if secretName == "" {
+		secretName = "websupport-api-secret"
+	}
+	return FileCredentials{
+		KeyFile:    filepath.Join(dir, keyName),
+		SecretFile: filepath.Join(dir, secretName),
+	}.Credentials(ctx)
+}
+
+// CommandCredentials runs an external command, such as a password
+// manager's CLI, that prints the API key and the secret on two lines.
+// There is no source for OS keyrings, which would need cgo or D-Bus; run
+// the keyring's CLI (secret-tool, security) with CommandCredentials
+// instead.
+type CommandCredentials struct {
+	Command string
+	Args    []string
+}
+
+// Credentials implements CredentialSource.
+func (c CommandCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Command, c.Args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("credential command %s failed: %v: %s", c.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		return Credentials{}, fmt.Errorf("credential command %s printed %d lines, want the API key and secret on two lines", c.Command, len(lines))
+	}
+	return Credentials{APIKey: strings.TrimSpace(lines[0]), APISecret: strings.TrimSpace(lines[1])}, nil
+}
+
+// readSecret returns the contents of a secret file without surrounding
+// whitespace.
+func readSecret(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials: %v", err)
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return "", fmt.Errorf("credentials file %s is empty", file)
+	}
+	return v, nil
+}
+
+// credentials returns the credentials to sign requests with: those of
+// CredentialSource if set, loaded on first use and again if reload is
+// set, or APIKey and APISecret.
+func (p *Provider) credentials(ctx context.Context, reload bool) (Credentials, error) {
+	if p.CredentialSource == nil {
+		return Credentials{APIKey: p.APIKey, APISecret: p.APISecret}, nil
+	}
+	p.credMu.Lock()
+	defer p.credMu.Unlock()
+	if p.creds != nil && !reload {
+		return *p.creds, nil
+	}
+	creds, err := p.CredentialSource.Credentials(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if creds.APIKey == "" || creds.APISecret == "" {
+		return Credentials{}, fmt.Errorf("credential source returned an empty API key or secret")
+	}
+	p.creds = &creds
+	return creds, nil
+}
@@ -0,0 +1,79 @@
+package websupport
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/libdns/libdns"
+)
+
+// Operation is a change to the zone that the provider would have made had
+// DryRun not been set.
+type Operation struct {
+	Action   string    `json:"action"` // "create", "update" or "delete"
+	Zone     string    `json:"zone"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	RecordID string    `json:"record_id,omitempty"`
+	Record   libdns.RR `json:"-"`
+	Body     any       `json:"body,omitempty"`
+}
+
+// MarshalJSON encodes the operation with the record's TTL in seconds.
+func (op Operation) MarshalJSON() ([]byte, error) {
+	type operation Operation
+	type record struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		TTL  int    `json:"ttl"`
+		Data string `json:"data"`
+	}
+	return json.Marshal(struct {
+		operation
+		Record record `json:"record"`
+	}{
+		operation: operation(op),
+		Record:    record{op.Record.Name, op.Record.Type, int(op.Record.TTL.Seconds()), op.Record.Data},
+	})
+}
+
+// Plan collects the operations planned in dry-run mode by the calls made
+// with a context returned by WithPlan. It is safe for concurrent use.
+type Plan struct {
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// Operations returns the operations planned so far, in the order they were
+// planned.
+func (pl *Plan) Operations() []Operation {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return slices.Clone(pl.ops)
+}
+
+type planKey struct{}
+
+// WithPlan returns a copy of ctx that makes dry-run calls add their planned
+// operations to plan. Calls sharing a plan add to it in the order their
+// operations are planned; use a plan per call to keep them apart.
+func WithPlan(ctx context.Context, plan *Plan) context.Context {
+	return context.WithValue(ctx, planKey{}, plan)
+}
+
+// planOperation logs op to Logger instead of executing it and adds it to
+// the plan of ctx, if any.
+func (p *Provider) planOperation(ctx context.Context, op Operation) {
+	p.logger().Info("websupport: dry run", slog.String("action", op.Action), slog.String("zone", op.Zone),
+		slog.String("name", op.Record.Name), slog.String("type", op.Record.Type), slog.String("data", op.Record.Data),
+		slog.String("method", op.Method), slog.String("path", op.Path))
+
+	if plan, ok := ctx.Value(planKey{}).(*Plan); ok && plan != nil {
+		plan.mu.Lock()
+		plan.ops = append(plan.ops, op)
+		plan.mu.Unlock()
+	}
+}
@@ -0,0 +1,54 @@
+package websupport
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+func TestDryRunPlansPerCall(t *testing.T) {
+	api := fakeapi.New(fakeapi.Record{Name: "old", Type: "A", Content: "1.1.1.1", TTL: 600})
+	p := testProvider(t, api)
+	p.DryRun = true
+
+	var appendPlan, deletePlan Plan
+	var appended []libdns.Record
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		var err error
+		appended, err = p.AppendRecords(WithPlan(context.Background(), &appendPlan), "example.com.", []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "token"},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	wg.Go(func() {
+		_, err := p.DeleteRecords(WithPlan(context.Background(), &deletePlan), "example.com.", []libdns.Record{
+			libdns.Address{Name: "old", IP: netip.MustParseAddr("1.1.1.1")},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	wg.Wait()
+
+	if ops := appendPlan.Operations(); len(ops) != 1 || ops[0].Action != "create" || ops[0].Record.Name != "_acme-challenge" {
+		t.Errorf("append plan %+v, want one create of _acme-challenge", ops)
+	}
+	if ops := deletePlan.Operations(); len(ops) != 1 || ops[0].Action != "delete" || ops[0].RecordID != "1001" {
+		t.Errorf("delete plan %+v, want one delete of record 1001", ops)
+	}
+	if len(appended) != 1 || appended[0].RR().TTL != 120*time.Second {
+		t.Errorf("appended %v, want the TXT record with its default TTL", appended)
+	}
+	if reqs := api.Requests(); len(reqs) != 0 {
+		t.Errorf("dry run sent %v", reqs)
+	}
+}
@@ -0,0 +1,35 @@
+package websupport
+
+import (
+	"log/slog"
+)
+
+// logger returns p.Logger, or a logger that discards everything.
+func (p *Provider) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return discardLogger
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// LogValue implements slog.LogValuer, so that logging a Provider never
+// reveals its credentials.
+func (p *Provider) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("api_key", redact(p.APIKey)),
+		slog.String("api_secret", redact(p.APISecret)),
+		slog.String("api_base", p.APIBase),
+		slog.String("service_id", p.ServiceID),
+		slog.Bool("credential_source", p.CredentialSource != nil),
+		slog.Bool("dry_run", p.DryRun),
+	)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "REDACTED"
+}
@@ -0,0 +1,140 @@
+package websupport
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics collects Prometheus metrics of the API calls and record changes
+// of one or more providers. Set it as Provider.Metrics, then either mount
+// Handler as /metrics or register the Metrics (a prometheus.Collector)
+// with an existing registry, such as the embedding process's default one.
+type Metrics struct {
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	retries     *prometheus.CounterVec
+	rateLimited *prometheus.CounterVec
+	records     *prometheus.CounterVec
+	pages       prometheus.Histogram
+
+	registry *prometheus.Registry
+}
+
+// NewMetrics creates the metrics, registered with a registry of their own
+// that Handler serves.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "websupport_api_requests_total",
+			Help: "Websupport API requests by method, endpoint and HTTP status (\"error\" if no response was received).",
+		}, []string{"method", "endpoint", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "websupport_api_request_duration_seconds",
+			Help:    "Latency of Websupport API requests by method and endpoint.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "endpoint"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "websupport_api_retries_total",
+			Help: "Websupport API requests that were retries of an earlier attempt.",
+		}, []string{"method", "endpoint"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "websupport_api_rate_limited_total",
+			Help: "Websupport API requests answered with 429 Too Many Requests.",
+		}, []string{"method", "endpoint"}),
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "websupport_records_total",
+			Help: "DNS records changed, by operation (created, updated or deleted) and record type.",
+		}, []string{"operation", "type"}),
+		pages: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "websupport_get_records_pages",
+			Help:    "Number of pages fetched to list the records of a zone.",
+			Buckets: []float64{1, 2, 3, 5, 10, 20, 50},
+		}),
+		registry: prometheus.NewRegistry(),
+	}
+	m.registry.MustRegister(m)
+	return m
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.duration.Describe(ch)
+	m.retries.Describe(ch)
+	m.rateLimited.Describe(ch)
+	m.records.Describe(ch)
+	m.pages.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.duration.Collect(ch)
+	m.retries.Collect(ch)
+	m.rateLimited.Collect(ch)
+	m.records.Collect(ch)
+	m.pages.Collect(ch)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// numericSegment matches the IDs in API paths, which would otherwise make
+// every record its own endpoint.
+var numericSegment = regexp.MustCompile(`/[0-9]+(/|$)`)
+
+// endpoint returns the API path with the query removed and IDs replaced
+// by {id}, e.g. /service/{id}/dns/record/{id}.
+func endpoint(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	// Replace twice, as adjacent IDs share the slash between them.
+	for range 2 {
+		path = numericSegment.ReplaceAllString(path, "/{id}$1")
+	}
+	return path
+}
+
+// observeRequest records one API request. status is 0 if no response was
+// received.
+func (m *Metrics) observeRequest(method, path string, status int, latency time.Duration, attempt int) {
+	if m == nil {
+		return
+	}
+	ep := endpoint(path)
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	m.requests.WithLabelValues(method, ep, code).Inc()
+	m.duration.WithLabelValues(method, ep).Observe(latency.Seconds())
+	if attempt > 1 {
+		m.retries.WithLabelValues(method, ep).Inc()
+	}
+	if status == http.StatusTooManyRequests {
+		m.rateLimited.WithLabelValues(method, ep).Inc()
+	}
+}
+
+// observeRecord records a created, updated or deleted record.
+func (m *Metrics) observeRecord(operation, typ string) {
+	if m == nil {
+		return
+	}
+	m.records.WithLabelValues(operation, typ).Inc()
+}
+
+// observePages records the number of pages a zone listing took.
+func (m *Metrics) observePages(pages int) {
+	if m == nil {
+		return
+	}
+	m.pages.Observe(float64(pages))
+}
@@ -0,0 +1,319 @@
+package websupport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// Account is one of the Websupport accounts of a MultiProvider.
+type Account struct {
+	APIKey    string `json:"api_key,omitempty"`
+	APISecret string `json:"api_secret,omitempty"`
+
+	// CredentialSource, if set, is used instead of APIKey and APISecret.
+	CredentialSource CredentialSource `json:"-"`
+
+	// Zones are glob patterns (e.g. "example.com" or "*.sk") of the zones
+	// the account may be used for. Empty allows every zone found in the
+	// account's service list.
+	Zones []string `json:"zones,omitempty"`
+
+	// ServiceIDs maps zones to their service IDs, for zones that should
+	// not be looked up in the service list.
+	ServiceIDs map[string]string `json:"service_ids,omitempty"`
+}
+
+// MultiProvider manages the zones of several Websupport accounts. Each
+// call is routed to the account whose zone globs match the zone and whose
+// service list contains it, in the order of Accounts.
+type MultiProvider struct {
+	Accounts []Account `json:"accounts"`
+	APIBase  string    `json:"api_base,omitempty"`
+
+	// Configure, if set, is called with each provider the MultiProvider
+	// creates, to set up e.g. its Logger, Metrics or Protection.
+	Configure func(*Provider) `json:"-"`
+
+	// ServiceListTTL is how long an account's service list is cached. A
+	// zone that isn't found refreshes lists older than a minute. Default:
+	// 1 hour.
+	ServiceListTTL time.Duration `json:"service_list_ttl,omitempty"`
+
+	// mu guards the caches only; lookups in the API are made without it,
+	// so that a slow account doesn't hold up calls for cached zones.
+	mu        sync.Mutex
+	providers map[string]zoneProvider // by zone
+	services  map[int]serviceList     // by account index
+}
+
+type zoneProvider struct {
+	provider *Provider
+	account  int // index in Accounts
+}
+
+type serviceList struct {
+	services []Service
+	fetched  time.Time
+}
+
+// Provider returns the provider of the account managing zone.
+func (m *MultiProvider) Provider(ctx context.Context, zone string) (*Provider, error) {
+	zone = normalizeZone(zone)
+
+	m.mu.Lock()
+	zp, ok := m.providers[zone]
+	m.mu.Unlock()
+	if ok {
+		return zp.provider, nil
+	}
+
+	var errs []string
+	for i := range m.Accounts {
+		acct := &m.Accounts[i]
+		if !acct.manages(zone) {
+			continue
+		}
+		serviceID, err := m.serviceID(ctx, i, zone)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("account %d: %v", i+1, err))
+			continue
+		}
+		if serviceID == "" {
+			continue
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// Another call may have looked the zone up meanwhile.
+		if zp, ok := m.providers[zone]; ok {
+			return zp.provider, nil
+		}
+		p := m.newProvider(acct, serviceID)
+		if m.providers == nil {
+			m.providers = make(map[string]zoneProvider)
+		}
+		m.providers[zone] = zoneProvider{p, i}
+		return p, nil
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("no account manages zone %s (%s)", zone, strings.Join(errs, "; "))
+	}
+	return nil, fmt.Errorf("no account manages zone %s", zone)
+}
+
+// forget drops the cached provider of zone and its account's service list
+// if err says the API doesn't know the zone, so that the next call looks
+// the zone up again.
+func (m *MultiProvider) forget(zone string, err error) {
+	if !errors.Is(err, ErrZoneNotFound) {
+		return
+	}
+	zone = normalizeZone(zone)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if zp, ok := m.providers[zone]; ok {
+		delete(m.providers, zone)
+		delete(m.services, zp.account)
+	}
+}
+
+// ListZones implements libdns.ZoneLister. It returns the domains in the
+// service lists of all accounts that their zone globs allow, and the
+// zones of ServiceIDs, in the order of Accounts.
+func (m *MultiProvider) ListZones(ctx context.Context) ([]libdns.Zone, error) {
+	var zones []libdns.Zone
+	seen := make(map[string]bool)
+	for i := range m.Accounts {
+		acct := &m.Accounts[i]
+		services, _, err := m.serviceList(ctx, i, false)
+		if err != nil {
+			return nil, fmt.Errorf("account %d: %v", i+1, err)
+		}
+		for _, s := range services {
+			zone := normalizeZone(s.Name)
+			if s.ServiceName == "domain" && acct.manages(zone) && !seen[zone] {
+				seen[zone] = true
+				zones = append(zones, libdns.Zone{Name: zone + "."})
+			}
+		}
+		for zone := range acct.ServiceIDs {
+			if zone = normalizeZone(zone); !seen[zone] {
+				seen[zone] = true
+				zones = append(zones, libdns.Zone{Name: zone + "."})
+			}
+		}
+	}
+	return zones, nil
+}
+
+// GetRecords implements libdns.RecordGetter.
+func (m *MultiProvider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	p, err := m.Provider(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := p.GetRecords(ctx, zone)
+	m.forget(zone, err)
+	return recs, err
+}
+
+// AppendRecords implements libdns.RecordAppender.
+func (m *MultiProvider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	p, err := m.Provider(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	created, err := p.AppendRecords(ctx, zone, recs)
+	m.forget(zone, err)
+	return created, err
+}
+
+// DeleteRecords implements libdns.RecordDeleter.
+func (m *MultiProvider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	p, err := m.Provider(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := p.DeleteRecords(ctx, zone, recs)
+	m.forget(zone, err)
+	return deleted, err
+}
+
+// SetRecords implements libdns.RecordSetter.
+func (m *MultiProvider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	p, err := m.Provider(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	set, err := p.SetRecords(ctx, zone, recs)
+	m.forget(zone, err)
+	return set, err
+}
+
+// UpdateRecord is Provider.UpdateRecord on the provider managing zone.
+func (m *MultiProvider) UpdateRecord(ctx context.Context, zone string, rec libdns.Record) (libdns.Record, error) {
+	p, err := m.Provider(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := p.UpdateRecord(ctx, zone, rec)
+	m.forget(zone, err)
+	return updated, err
+}
+
+// manages reports whether the account's zone globs allow zone.
+func (a *Account) manages(zone string) bool {
+	if len(a.Zones) == 0 {
+		return true
+	}
+	for _, pattern := range a.Zones {
+		if globMatch(normalizeZone(pattern), zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceID returns the ID of the domain service of zone in account i, or
+// "" if the account has none. A miss refreshes a service list older than a
+// minute, so newly added domains are found.
+func (m *MultiProvider) serviceID(ctx context.Context, i int, zone string) (string, error) {
+	for z, id := range m.Accounts[i].ServiceIDs {
+		if normalizeZone(z) == zone {
+			return id, nil
+		}
+	}
+	services, fetched, err := m.serviceList(ctx, i, false)
+	if err != nil {
+		return "", err
+	}
+	id := findService(services, zone)
+	if id == "" && time.Since(fetched) > time.Minute {
+		if services, _, err = m.serviceList(ctx, i, true); err != nil {
+			return "", err
+		}
+		id = findService(services, zone)
+	}
+	return id, nil
+}
+
+// serviceList returns the services of account i and when they were
+// fetched, cached for ServiceListTTL unless refresh is set.
+func (m *MultiProvider) serviceList(ctx context.Context, i int, refresh bool) ([]Service, time.Time, error) {
+	ttl := m.ServiceListTTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	m.mu.Lock()
+	l, ok := m.services[i]
+	m.mu.Unlock()
+	if ok && !refresh && time.Since(l.fetched) < ttl {
+		return l.services, l.fetched, nil
+	}
+
+	services, err := m.newProvider(&m.Accounts[i], "").ListServices(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	l = serviceList{services: services, fetched: time.Now()}
+	m.mu.Lock()
+	if m.services == nil {
+		m.services = make(map[int]serviceList)
+	}
+	m.services[i] = l
+	m.mu.Unlock()
+	return l.services, l.fetched, nil
+}
+
+// findService returns the ID of the service named zone, preferring domain
+// services.
+func findService(services []Service, zone string) string {
+	id := ""
+	for _, s := range services {
+		if normalizeZone(s.Name) != zone {
+			continue
+		}
+		if s.ServiceName == "domain" {
+			return strconv.Itoa(s.ID)
+		}
+		if id == "" {
+			id = strconv.Itoa(s.ID)
+		}
+	}
+	return id
+}
+
+func (m *MultiProvider) newProvider(acct *Account, serviceID string) *Provider {
+	p := &Provider{
+		APIKey:           acct.APIKey,
+		APISecret:        acct.APISecret,
+		CredentialSource: acct.CredentialSource,
+		APIBase:          m.APIBase,
+		ServiceID:        serviceID,
+	}
+	if m.Configure != nil {
+		m.Configure(p)
+	}
+	return p
+}
+
+// normalizeZone returns zone in lower case without the trailing dot.
+func normalizeZone(zone string) string {
+	return strings.TrimSuffix(strings.ToLower(zone), ".")
+}
+
+// Interface guards
+var (
+	_ libdns.RecordGetter   = (*MultiProvider)(nil)
+	_ libdns.RecordAppender = (*MultiProvider)(nil)
+	_ libdns.RecordDeleter  = (*MultiProvider)(nil)
+	_ libdns.RecordSetter   = (*MultiProvider)(nil)
+	_ libdns.ZoneLister     = (*MultiProvider)(nil)
+)
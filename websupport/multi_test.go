@@ -0,0 +1,106 @@
+package websupport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+// testServices serves the service list of an account, with example.com as
+// service *id, and passes the record endpoints of that service on to api.
+// Other service IDs are not found.
+func testServices(api http.Handler, id *atomic.Int64, list http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/service" {
+			if list != nil {
+				list(w, r)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"currentPage": 1,
+				"totalPages":  1,
+				"data":        []Service{{ID: int(id.Load()), Name: "example.com", ServiceName: "domain"}},
+			})
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/service/"+strconv.FormatInt(id.Load(), 10)+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
+
+func TestMultiProviderForgetsMissingZone(t *testing.T) {
+	var id atomic.Int64
+	id.Store(1)
+	var lists atomic.Int32
+	srv := httptest.NewServer(testServices(fakeapi.New(fakeapi.Record{Name: "www", Type: "A", Content: "192.0.2.1"}), &id,
+		func(http.ResponseWriter, *http.Request) { lists.Add(1) }))
+	t.Cleanup(srv.Close)
+	m := &MultiProvider{APIBase: srv.URL, Accounts: []Account{{APIKey: "key", APISecret: "secret"}}}
+	ctx := context.Background()
+
+	if _, err := m.GetRecords(ctx, "example.com."); err != nil {
+		t.Fatal(err)
+	}
+	// The domain is re-added to the account under a new service ID.
+	id.Store(2)
+	if _, err := m.GetRecords(ctx, "example.com."); !errors.Is(err, ErrZoneNotFound) {
+		t.Fatalf("GetRecords with a stale service ID: err = %v, want ErrZoneNotFound", err)
+	}
+	recs, err := m.GetRecords(ctx, "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Errorf("got %d records, want 1", len(recs))
+	}
+	if n := lists.Load(); n != 2 {
+		t.Errorf("service list fetched %d times, want 2", n)
+	}
+}
+
+func TestMultiProviderLooksUpWithoutLock(t *testing.T) {
+	var id atomic.Int64
+	id.Store(1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(testServices(fakeapi.New(), &id,
+		func(http.ResponseWriter, *http.Request) { <-release }))
+	t.Cleanup(srv.Close)
+	defer close(release)
+	m := &MultiProvider{APIBase: srv.URL, Accounts: []Account{
+		{APIKey: "key", APISecret: "secret", ServiceIDs: map[string]string{"example.com": "1"}},
+	}}
+	ctx := context.Background()
+
+	if _, err := m.GetRecords(ctx, "example.com."); err != nil {
+		t.Fatal(err)
+	}
+	// The lookup of example.org waits for the service list.
+	go m.GetRecords(ctx, "example.org.")
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.GetRecords(ctx, "example.com.")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call for a cached zone waited for another zone's lookup")
+	}
+}
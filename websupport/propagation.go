@@ -0,0 +1,59 @@
+package websupport
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/libdns/websupport/propagation"
+)
+
+// waitForPropagation waits until the authoritative nameservers of zone
+// serve all of items, or ctx ends. Without a deadline on ctx, the
+// checker's own timeout applies.
+func (p *Provider) waitForPropagation(ctx context.Context, zone string, items []apiRecord) error {
+	checker := propagation.Checker{}
+	if p.PropagationChecker != nil {
+		checker = *p.PropagationChecker
+	}
+	if deadline, ok := ctx.Deadline(); ok && checker.Timeout == 0 {
+		checker.Timeout = time.Until(deadline)
+	}
+
+	recs := make([]libdns.RR, len(items))
+	for i, item := range items {
+		recs[i] = item.withAbsoluteTarget(zone).rr()
+	}
+	ctx, span := p.tracer().Start(ctx, "websupport.waitForPropagation",
+		trace.WithAttributes(attribute.String("dns.zone", zone), attribute.Int("dns.record.count", len(recs))))
+	results, err := checker.Wait(ctx, zone, recs)
+	endOperation(span, len(results), err)
+	return err
+}
+
+// withAbsoluteTarget returns the record with the host name in its content
+// made absolute, as nameservers answer with it. Websupport takes names
+// with a dot to be absolute already; "@" is the apex and a single label
+// is relative to zone.
+func (a apiRecord) withAbsoluteTarget(zone string) apiRecord {
+	switch a.Type {
+	case "CNAME", "NS", "MX", "SRV", "PTR":
+	default:
+		return a
+	}
+	zone = strings.TrimSuffix(zone, ".")
+	switch host := strings.TrimSuffix(a.Content, "."); {
+	case host == "@":
+		a.Content = zone + "."
+	case strings.HasSuffix(a.Content, "."):
+	case !strings.Contains(host, "."):
+		a.Content = host + "." + zone + "."
+	default:
+		a.Content = host + "."
+	}
+	return a
+}
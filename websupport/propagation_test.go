@@ -0,0 +1,24 @@
+package websupport
+
+import "testing"
+
+func TestWithAbsoluteTarget(t *testing.T) {
+	for _, tt := range []struct {
+		rec  apiRecord
+		want string
+	}{
+		{apiRecord{Type: "MX", Content: "mail", Prio: 10}, "mail.example.com."},
+		{apiRecord{Type: "MX", Content: "mail.example.net", Prio: 10}, "mail.example.net."},
+		{apiRecord{Type: "CNAME", Content: "@"}, "example.com."},
+		{apiRecord{Type: "CNAME", Content: "pages.example.net."}, "pages.example.net."},
+		{apiRecord{Type: "SRV", Content: "sip", Prio: 10, Weight: 5, Port: 5060}, "sip.example.com."},
+		{apiRecord{Type: "NS", Content: "ns1.example.net"}, "ns1.example.net."},
+		{apiRecord{Type: "TXT", Content: "mail"}, "mail"},
+		{apiRecord{Type: "A", Content: "192.0.2.1"}, "192.0.2.1"},
+	} {
+		got := tt.rec.withAbsoluteTarget("example.com.")
+		if got.Content != tt.want || got.Prio != tt.rec.Prio || got.Port != tt.rec.Port {
+			t.Errorf("%s %q: got %+v, want content %q", tt.rec.Type, tt.rec.Content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package websupport
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/libdns/libdns"
+)
+
+// ProtectionRule matches records by name, type and Websupport note. Name and
+// Type are glob patterns (see path.Match) compared case-insensitively; Name
+// is relative to the zone, with "@" for the apex. Empty fields match any
+// record.
+type ProtectionRule struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+	Note string `json:"note,omitempty"`
+}
+
+// String describes the rule for error messages.
+func (r ProtectionRule) String() string {
+	var parts []string
+	if r.Name != "" {
+		parts = append(parts, "name="+r.Name)
+	}
+	if r.Type != "" {
+		parts = append(parts, "type="+r.Type)
+	}
+	if r.Note != "" {
+		parts = append(parts, "note="+r.Note)
+	}
+	if len(parts) == 0 {
+		return "any record"
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r ProtectionRule) matches(a apiRecord) bool {
+	return globMatch(r.Name, a.Name) && globMatch(r.Type, a.Type) && globMatch(r.Note, a.Note)
+}
+
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return err == nil && ok
+}
+
+// ProtectionPolicy guards records against being created, changed or deleted
+// through the provider. A record is protected if it matches any of Protect,
+// or if Allow is non-empty and it matches none of Allow.
+type ProtectionPolicy struct {
+	Protect []ProtectionRule `json:"protect,omitempty"`
+	Allow   []ProtectionRule `json:"allow,omitempty"`
+}
+
+// DefaultProtection returns a policy protecting the records a zone can
+// least afford to lose: the apex NS and MX records, SPF/DMARC/DKIM policy
+// records and anything with a "protected" note.
+func DefaultProtection() *ProtectionPolicy {
+	return &ProtectionPolicy{
+		Protect: []ProtectionRule{
+			{Name: "@", Type: "NS"},
+			{Name: "@", Type: "MX"},
+			{Name: "@", Type: "SOA"},
+			{Name: "_dmarc", Type: "TXT"},
+			{Name: "*._domainkey", Type: "TXT"},
+			{Note: "protected"},
+		},
+	}
+}
+
+// ACMEChallengeOnly returns a policy that allows touching nothing but
+// _acme-challenge TXT records.
+func ACMEChallengeOnly() *ProtectionPolicy {
+	return &ProtectionPolicy{
+		Allow: []ProtectionRule{
+			{Name: "_acme-challenge", Type: "TXT"},
+			{Name: "_acme-challenge.*", Type: "TXT"},
+		},
+	}
+}
+
+// check returns the reason a is protected, or "" if it is not.
+func (pp *ProtectionPolicy) check(a apiRecord) string {
+	if pp == nil {
+		return ""
+	}
+	for _, r := range pp.Protect {
+		if r.matches(a) {
+			return "matches protection rule " + r.String()
+		}
+	}
+	if len(pp.Allow) == 0 {
+		return ""
+	}
+	for _, r := range pp.Allow {
+		if r.matches(a) {
+			return ""
+		}
+	}
+	return "not allowed by any rule"
+}
+
+// ProtectedRecordError is returned when a mutation would touch a record
+// protected by the provider's ProtectionPolicy. No changes are made by the
+// call that returns it.
+type ProtectedRecordError struct {
+	Action string // "create", "update" or "delete"
+	Zone   string
+	Record libdns.RR
+	Reason string
+}
+
+func (e *ProtectedRecordError) Error() string {
+	return fmt.Sprintf("refusing to %s protected record %s %s in %s: %s",
+		e.Action, e.Record.Name, e.Record.Type, e.Zone, e.Reason)
+}
+
+// checkProtection returns a *ProtectedRecordError if a is protected.
+func (p *Provider) checkProtection(action, zone string, a apiRecord) error {
+	if reason := p.Protection.check(a); reason != "" {
+		return &ProtectedRecordError{Action: action, Zone: zone, Record: a.rr(), Reason: reason}
+	}
+	return nil
+}
@@ -1,18 +1,27 @@
 package websupport
 
 import (
+	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/libdns/libdns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/libdns/websupport/propagation"
 )
 
 // Provider implements the libdns interfaces for Websupport's DNS API.
@@ -22,8 +31,67 @@ type Provider struct {
 	APIBase   string `json:"api_base,omitempty"`
 	ServiceID string `json:"service_id,omitempty"` // Service ID for the domain
 
+	// CredentialSource, if set, supplies the API key and secret instead
+	// of APIKey and APISecret. They are re-read when the API answers 401
+	// Unauthorized and the request is retried once.
+	CredentialSource CredentialSource `json:"-"`
+
+	// RateLimit limits the rate of API requests, across all providers
+	// with the same API key and RateLimit, and enables retrying requests
+	// answered with 429 Too Many Requests. Regardless of it, requests are
+	// held back when the API's rate limit headers say the limit is
+	// exhausted.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+
+	// BatchConcurrency bounds the records AppendRecords and DeleteRecords
+	// create or delete at once. Default: 4.
+	BatchConcurrency int `json:"batch_concurrency,omitempty"`
+
+	// BatchRollback makes AppendRecords delete the records it created if
+	// any record of the call could not be created, so that it creates all
+	// of them or none.
+	BatchRollback bool `json:"batch_rollback,omitempty"`
+
 	HTTPClient *http.Client
 	Timeout    time.Duration
+
+	// DryRun makes AppendRecords, DeleteRecords and UpdateRecord resolve
+	// IDs and validate records as usual, but plan the changes, logging them
+	// to Logger, instead of calling the API. See WithPlan.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Protection refuses mutations of protected records with a
+	// *ProtectedRecordError. Nil protects nothing.
+	Protection *ProtectionPolicy `json:"protection,omitempty"`
+
+	// WaitForPropagation makes AppendRecords return only once the new
+	// records are served by all authoritative nameservers of the zone, or
+	// the context ends. If they lag, the created records are returned with
+	// a *propagation.Error naming the nameservers that lagged.
+	WaitForPropagation bool `json:"wait_for_propagation,omitempty"`
+
+	// PropagationChecker configures the wait, e.g. its interval or the
+	// nameservers to query. Nil looks the nameservers up from the zone's
+	// NS records.
+	PropagationChecker *propagation.Checker `json:"-"`
+
+	// Logger receives a debug record for each API call (method, path,
+	// status, latency, attempt) and info records summarising each
+	// operation. Credentials and request bodies are never logged. Nil
+	// disables logging.
+	Logger *slog.Logger `json:"-"`
+
+	// Metrics, if set, counts API requests and record changes. It may be
+	// shared by several providers.
+	Metrics *Metrics `json:"-"`
+
+	// TracerProvider, if set, receives a span for each libdns method call
+	// with a child span per API request. The trace context of ctx is
+	// continued and passed on to the API in W3C traceparent headers.
+	TracerProvider trace.TracerProvider `json:"-"`
+
+	credMu sync.Mutex
+	creds  *Credentials // loaded from CredentialSource
 }
 
 // SECURITY NOTE:
@@ -31,8 +99,14 @@ type Provider struct {
 // - Prefer loading `APIKey` and `APISecret` from environment variables or a secure secret store.
 // - When publishing to GitHub, double-check no secrets are committed.
 
-// ensureClient initializes the HTTP client if not set.
+// DefaultAPIBase is the Websupport REST API used when APIBase is not set.
+const DefaultAPIBase = "https://rest.websupport.sk/v2"
+
+// ensureClient initializes the HTTP client and API base if not set.
 func (p *Provider) ensureClient() {
+	if p.APIBase == "" {
+		p.APIBase = DefaultAPIBase
+	}
 	if p.HTTPClient == nil {
 		p.HTTPClient = &http.Client{Timeout: 30 * time.Second}
 	}
@@ -42,190 +116,461 @@ func (p *Provider) ensureClient() {
 }
 
 // calculateSignature generates HMAC-SHA1 signature for Websupport API authentication
-func (p *Provider) calculateSignature(method, path string, timestamp int64) string {
+func calculateSignature(secret, method, path string, timestamp int64) string {
 	canonicalRequest := fmt.Sprintf("%s %s %d", method, path, timestamp)
-	h := hmac.New(sha1.New, []byte(p.APISecret))
+	h := hmac.New(sha1.New, []byte(secret))
 	h.Write([]byte(canonicalRequest))
 	return hex.EncodeToString(h.Sum(nil))
 }
 
 // addAuthHeaders adds required authentication headers to the request
-func (p *Provider) addAuthHeaders(req *http.Request, method, path string) {
+func addAuthHeaders(req *http.Request, creds Credentials, method, path string) {
 	timestamp := time.Now().Unix()
-	signature := p.calculateSignature(method, path, timestamp)
-	req.SetBasicAuth(p.APIKey, signature)
+	signature := calculateSignature(creds.APISecret, method, path, timestamp)
+	req.SetBasicAuth(creds.APIKey, signature)
 	req.Header.Set("X-Date", time.Unix(timestamp, 0).UTC().Format("20060102T150405Z"))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 }
 
-// AppendRecords creates DNS records (used for ACME TXT records).
+// doRequest sends an authenticated request to the API. path is relative to
+// APIBase (i.e. without the /v2 prefix) and may carry a query string; the
+// signature is calculated over the /v2 path without the query. A non-nil
+// body is encoded as JSON. Requests wait for the account's rate limiter,
+// are retried when answered with 429 Too Many Requests if RateLimit allows,
+// and with a CredentialSource once with re-read credentials on 401
+// Unauthorized.
+func (p *Provider) doRequest(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	sigPath, _, _ := strings.Cut("/v2"+path, "?")
+
+	reload, reloaded := false, false
+	retries := 0
+	for attempt := 1; ; attempt++ {
+		creds, err := p.credentials(ctx, reload)
+		if err != nil {
+			return nil, err
+		}
+		reload = false
+		limiter := p.limiter(creds.APIKey)
+		start := time.Now()
+		if err := limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		if queued := time.Since(start); queued > 10*time.Millisecond {
+			p.logger().DebugContext(ctx, "websupport: API request queued by rate limit",
+				slog.String("method", method), slog.String("path", path), slog.Duration("queued", queued))
+		}
+
+		var reqBody io.Reader
+		if b != nil {
+			reqBody = bytes.NewReader(b)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, p.APIBase+path, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		addAuthHeaders(req, creds, method, sigPath)
+
+		resp, err := p.send(req, path, attempt)
+		if err != nil {
+			return nil, err
+		}
+		limiter.observe(resp)
+		if resp.StatusCode == http.StatusTooManyRequests && retries < p.maxRetries() {
+			resp.Body.Close()
+			retries++
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized && p.CredentialSource != nil && !reloaded {
+			// The credentials may have been rotated since they were read.
+			resp.Body.Close()
+			reload, reloaded = true, true
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// send sends one attempt of an API request, tracing, measuring and
+// logging it.
+func (p *Provider) send(req *http.Request, path string, attempt int) (*http.Response, error) {
+	req, span := p.startRequest(req, path, attempt)
+	ctx := req.Context()
+	start := time.Now()
+	resp, err := p.HTTPClient.Do(req)
+	latency := time.Since(start)
+	endRequest(span, resp, err)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	p.Metrics.observeRequest(req.Method, path, status, latency, attempt)
+
+	attrs := []any{
+		slog.String("method", req.Method),
+		slog.String("path", path),
+		slog.Duration("latency", latency),
+		slog.Int("attempt", attempt),
+	}
+	switch {
+	case err != nil:
+		p.logger().WarnContext(ctx, "websupport: API request failed", append(attrs, slog.Any("error", err))...)
+	case resp.StatusCode >= 400:
+		p.logger().WarnContext(ctx, "websupport: API request", append(attrs, slog.Int("status", resp.StatusCode))...)
+	default:
+		p.logger().DebugContext(ctx, "websupport: API request", append(attrs, slog.Int("status", resp.StatusCode))...)
+	}
+	return resp, err
+}
+
+// AppendRecords creates DNS records (used for ACME TXT records). TXT
+// records without a TTL get 120 seconds, other records Websupport's default.
+// Up to BatchConcurrency records are created at once. If some fail, the
+// records that were created are returned with a *BatchError, or deleted
+// again if BatchRollback is set.
 func (p *Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	ctx, span := p.startOperation(ctx, "AppendRecords", zone, recs)
+	created, err := p.appendRecords(ctx, zone, recs)
+	endOperation(span, len(created), err)
+	return created, err
+}
+
+func (p *Provider) appendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
 	p.ensureClient()
 
 	if p.ServiceID == "" {
 		return nil, fmt.Errorf("ServiceID is required - set WEBSUPPORT_SERVICE_ID environment variable")
 	}
 
-	var created []libdns.Record
+	// Validate everything up front, so a protected or invalid record
+	// doesn't leave the zone half-changed.
+	items := make([]apiRecord, 0, len(recs))
 	for _, rec := range recs {
-		// Type assert to TXT record
-		r, ok := rec.(*libdns.TXT)
-		if !ok {
-			continue
-		}
-
-		if r.TTL == 0 {
+		if r, ok := rec.(*libdns.TXT); ok && r.TTL == 0 {
 			r.TTL = 120 * time.Second
 		}
-
-		// Always create TXT records for ACME DNS-01
-		body := fmt.Sprintf(`{"type":"TXT","name":"%s","content":"%s","ttl":%d}`,
-			r.Name, r.Text, int(r.TTL.Seconds()))
-
-		// URL path for HTTP request (without /v2 prefix)
-		urlPath := fmt.Sprintf("/service/%s/dns/record", p.ServiceID)
-		// Signature path must include /v2 prefix
-		sigPath := fmt.Sprintf("/v2/service/%s/dns/record", p.ServiceID)
-
-		req, err := http.NewRequestWithContext(ctx, "POST",
-			p.APIBase+urlPath,
-			strings.NewReader(body))
-		if err != nil {
+		item := newAPIRecord(rec, zone)
+		if item.Type == "TXT" && item.TTL == 0 {
+			item.TTL = 120
+		}
+		if err := item.validate(); err != nil {
 			return nil, err
 		}
-
-		p.addAuthHeaders(req, "POST", sigPath)
-
-		resp, err := p.HTTPClient.Do(req)
-		if err != nil {
+		if err := p.checkProtection("create", zone, item); err != nil {
 			return nil, err
 		}
-		defer resp.Body.Close()
+		items = append(items, item)
+	}
 
-		if resp.StatusCode != 204 {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("failed to create record: %s, body: %s", resp.Status, string(bodyBytes))
+	if p.DryRun {
+		path := fmt.Sprintf("/service/%s/dns/record", p.ServiceID)
+		planned := make([]libdns.Record, 0, len(items))
+		for _, item := range items {
+			p.planOperation(ctx, Operation{Action: "create", Zone: zone, Method: "POST", Path: path, Record: item.rr(), Body: item})
+			planned = append(planned, item.libdnsRecord())
 		}
+		return planned, nil
+	}
 
-		// Websupport returns 204 No Content on success
-		// We need to fetch the record to get its ID
-		time.Sleep(1 * time.Second) // Give DNS time to propagate
+	// The API doesn't return the IDs of new records; one listing after
+	// all of them were created finds them among the records that weren't
+	// there before.
+	before, err := p.listRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool, len(before))
+	for _, rec := range before {
+		seen[rec.ID] = true
+	}
 
-		allRecs, err := p.GetRecords(ctx, zone)
-		if err == nil {
-			// Find the record we just created by content (most reliable)
-			// Name comparison needs to handle FQDN vs relative names
-			normalizedName := strings.TrimSuffix(r.Name, ".")
-			for _, existingRec := range allRecs {
-				if txtRec, ok := existingRec.(*libdns.TXT); ok {
-					existingName := strings.TrimSuffix(txtRec.Name, ".")
-					existingName = strings.TrimSuffix(existingName, "."+strings.TrimSuffix(zone, "."))
-					if existingName == normalizedName && txtRec.Text == r.Text {
-						r.ProviderData = txtRec.ProviderData
-						break
-					}
-				}
+	errs := p.runBatch(ctx, len(items), p.BatchRollback, func(i int) error {
+		return p.createRecord(ctx, items[i])
+	})
+
+	// The listing continues if ctx ended meanwhile, so that records
+	// created before a cancellation are still found and can be rolled back.
+	var existing []apiRecord
+	if slices.Contains(errs, nil) {
+		time.Sleep(1 * time.Second) // Give DNS time to propagate
+		listCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+		existing, _ = p.listRecords(listCtx, zone)
+		cancel()
+	}
+	var created []libdns.Record
+	var createdIDs []string
+	var failed []RecordError
+	for i, rec := range recs {
+		if errs[i] != nil {
+			failed = append(failed, RecordError{Record: items[i].rr(), Err: errs[i]})
+			continue
+		}
+		id := ""
+		if found, ok := findNewAPIRecord(existing, seen, items[i]); ok {
+			id = fmt.Sprintf("%d", found.ID)
+		}
+		created = append(created, WithRecordID(rec, id))
+		createdIDs = append(createdIDs, id)
+		p.Metrics.observeRecord("created", items[i].Type)
+	}
+	p.logger().InfoContext(ctx, "websupport: records created", slog.String("zone", zone),
+		slog.Int("count", len(created)), slog.Int("failed", len(failed)))
+
+	if len(failed) > 0 {
+		berr := &BatchError{Action: "create", Zone: zone, Succeeded: created, Failed: failed}
+		// Created records that don't carry their new ID are left alone:
+		// deleting them by content could hit an identical record that
+		// existed before.
+		var undo []libdns.Record
+		for i, rec := range created {
+			if createdIDs[i] != "" && RecordID(rec) == createdIDs[i] {
+				undo = append(undo, rec)
 			}
 		}
-
-		created = append(created, r)
+		if p.BatchRollback && len(undo) > 0 {
+			rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+			berr.RolledBack, berr.RollbackErr = p.deleteRecords(rollbackCtx, zone, undo)
+			cancel()
+			var bdel *BatchError
+			if errors.As(berr.RollbackErr, &bdel) {
+				berr.RolledBack = bdel.Succeeded
+			}
+			berr.Succeeded = withoutRecords(created, berr.RolledBack)
+			p.logger().WarnContext(ctx, "websupport: created records rolled back", slog.String("zone", zone),
+				slog.Int("count", len(berr.RolledBack)), slog.Any("error", berr.RollbackErr))
+		}
+		return berr.Succeeded, berr
 	}
 
+	if p.WaitForPropagation && len(items) > 0 {
+		start := time.Now()
+		if err := p.waitForPropagation(ctx, zone, items); err != nil {
+			p.logger().WarnContext(ctx, "websupport: records not propagated", slog.String("zone", zone), slog.Any("error", err))
+			return created, err
+		}
+		p.logger().InfoContext(ctx, "websupport: records propagated", slog.String("zone", zone),
+			slog.Int("count", len(items)), slog.Duration("latency", time.Since(start)))
+	}
 	return created, nil
 }
 
-// DeleteRecords removes DNS records by ID.
+// DeleteRecords removes DNS records by ID. Records without an ID are looked
+// up by name, type and content; records which cannot be found are skipped.
+// Up to BatchConcurrency records are deleted at once. If some fail, the
+// records that were deleted are returned with a *BatchError.
 func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	ctx, span := p.startOperation(ctx, "DeleteRecords", zone, recs)
+	deleted, err := p.deleteRecords(ctx, zone, recs)
+	endOperation(span, len(deleted), err)
+	return deleted, err
+}
+
+func (p *Provider) deleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
 	p.ensureClient()
 
 	if p.ServiceID == "" {
 		return nil, fmt.Errorf("ServiceID is required - set WEBSUPPORT_SERVICE_ID environment variable")
 	}
 
-	var deleted []libdns.Record
-	for _, rec := range recs {
-		// Type assert to TXT record
-		r, ok := rec.(*libdns.TXT)
-		if !ok {
-			continue
+	// The zone is listed at most once, to resolve missing IDs and to know
+	// the notes of the records for the protection policy.
+	var existing []apiRecord
+	loaded := false
+	load := func() ([]apiRecord, error) {
+		if loaded {
+			return existing, nil
 		}
+		var err error
+		existing, err = p.listRecords(ctx, zone)
+		loaded = err == nil
+		return existing, err
+	}
 
-		// Extract ID from ProviderData
-		id, ok := r.ProviderData.(string)
-		if !ok || id == "" {
-			// Try to find the record by name and content
-			allRecs, err := p.GetRecords(ctx, zone)
+	type deletion struct {
+		rec  libdns.Record
+		item apiRecord
+		id   string
+	}
+	var todo []deletion
+	for _, rec := range recs {
+		item := newAPIRecord(rec, zone)
+		id := RecordID(rec)
+		if id == "" || p.Protection != nil {
+			all, err := load()
 			if err != nil {
-				continue
-			}
-			for _, existingRec := range allRecs {
-				if txtRec, ok := existingRec.(*libdns.TXT); ok {
-					if txtRec.Name == r.Name && txtRec.Text == r.Text {
-						id, _ = txtRec.ProviderData.(string)
-						break
-					}
-				}
+				return nil, err
 			}
 			if id == "" {
-				continue
+				// Find the record by name and content
+				found, ok := findAPIRecord(all, item)
+				if !ok {
+					continue
+				}
+				item = found
+				id = fmt.Sprintf("%d", found.ID)
+			} else if found, ok := findAPIRecordByID(all, id); ok {
+				// Check protection against the record as it is in the zone;
+				// a stale ID is deleted as is, like without Protection
+				item = found
 			}
 		}
+		if err := p.checkProtection("delete", zone, item); err != nil {
+			return nil, err
+		}
+		todo = append(todo, deletion{rec, item, id})
+	}
 
-		urlPath := fmt.Sprintf("/service/%s/dns/record/%s", p.ServiceID, id)
-		sigPath := fmt.Sprintf("/v2/service/%s/dns/record/%s", p.ServiceID, id)
+	var deleted []libdns.Record
+	if p.DryRun {
+		for _, d := range todo {
+			path := fmt.Sprintf("/service/%s/dns/record/%s", p.ServiceID, d.id)
+			p.planOperation(ctx, Operation{Action: "delete", Zone: zone, Method: "DELETE", Path: path, RecordID: d.id, Record: d.item.rr()})
+			deleted = append(deleted, d.rec)
+		}
+		return deleted, nil
+	}
 
-		req, err := http.NewRequestWithContext(ctx, "DELETE",
-			p.APIBase+urlPath, nil)
-		if err != nil {
-			return nil, err
+	errs := p.runBatch(ctx, len(todo), false, func(i int) error {
+		return p.deleteRecord(ctx, todo[i].id)
+	})
+	var failed []RecordError
+	for i, d := range todo {
+		if errs[i] != nil {
+			failed = append(failed, RecordError{Record: d.item.rr(), Err: errs[i]})
+			continue
 		}
+		deleted = append(deleted, d.rec)
+		p.Metrics.observeRecord("deleted", d.item.Type)
+	}
+	p.logger().InfoContext(ctx, "websupport: records deleted", slog.String("zone", zone),
+		slog.Int("count", len(deleted)), slog.Int("failed", len(failed)), slog.Int("skipped", len(recs)-len(todo)))
+	if len(failed) > 0 {
+		return deleted, &BatchError{Action: "delete", Zone: zone, Succeeded: deleted, Failed: failed}
+	}
+	return deleted, nil
+}
 
-		p.addAuthHeaders(req, "DELETE", sigPath)
+// UpdateRecord changes the record identified by rec's ID (see RecordID) to
+// the name, type, TTL and content of rec.
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, rec libdns.Record) (libdns.Record, error) {
+	ctx, span := p.startOperation(ctx, "UpdateRecord", zone, []libdns.Record{rec})
+	updated, err := p.updateRecord(ctx, zone, rec)
+	n := 0
+	if updated != nil {
+		n = 1
+	}
+	endOperation(span, n, err)
+	return updated, err
+}
+
+func (p *Provider) updateRecord(ctx context.Context, zone string, rec libdns.Record) (libdns.Record, error) {
+	p.ensureClient()
 
-		resp, err := p.HTTPClient.Do(req)
+	if p.ServiceID == "" {
+		return nil, fmt.Errorf("ServiceID is required - set WEBSUPPORT_SERVICE_ID environment variable")
+	}
+
+	id := RecordID(rec)
+	if id == "" {
+		return nil, fmt.Errorf("cannot update record %s %s without an ID", rec.RR().Name, rec.RR().Type)
+	}
+
+	item := newAPIRecord(rec, zone)
+	if err := item.validate(); err != nil {
+		return nil, err
+	}
+	if p.Protection != nil {
+		// Both the record as it is and as it would become must be
+		// unprotected.
+		existing, err := p.listRecords(ctx, zone)
 		if err != nil {
 			return nil, err
 		}
-		resp.Body.Close()
-
-		if resp.StatusCode != 204 {
-			return nil, fmt.Errorf("failed to delete record: %s", resp.Status)
+		if current, ok := findAPIRecordByID(existing, id); ok {
+			if err := p.checkProtection("update", zone, current); err != nil {
+				return nil, err
+			}
+			item.Note = current.Note
+		}
+		if err := p.checkProtection("update", zone, item); err != nil {
+			return nil, err
 		}
+	}
 
-		deleted = append(deleted, r)
+	path := fmt.Sprintf("/service/%s/dns/record/%s", p.ServiceID, id)
+	if p.DryRun {
+		p.planOperation(ctx, Operation{Action: "update", Zone: zone, Method: "PUT", Path: path, RecordID: id, Record: item.rr(), Body: item})
+		return rec, nil
 	}
-	return deleted, nil
+
+	if err := p.updateRecordByID(ctx, id, item); err != nil {
+		return nil, err
+	}
+	p.Metrics.observeRecord("updated", item.Type)
+	p.logger().InfoContext(ctx, "websupport: record updated", slog.String("zone", zone),
+		slog.String("id", id), slog.String("name", item.Name), slog.String("type", item.Type))
+	return rec, nil
 }
 
-// GetRecords retrieves all DNS records from the zone.
+// GetRecords retrieves all DNS records from the zone. Records of a type
+// known to libdns are returned as their concrete type (e.g. *libdns.TXT),
+// anything else as a libdns.RR.
 func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	ctx, span := p.startOperation(ctx, "GetRecords", zone, nil)
+	recs, err := p.getRecords(ctx, zone)
+	endOperation(span, len(recs), err)
+	return recs, err
+}
+
+func (p *Provider) getRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
 	p.ensureClient()
 
 	if p.ServiceID == "" {
 		return nil, fmt.Errorf("ServiceID is required - set WEBSUPPORT_SERVICE_ID environment variable")
 	}
 
-	var allRecords []libdns.Record
+	items, err := p.listRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	allRecords := make([]libdns.Record, 0, len(items))
+	for _, item := range items {
+		allRecords = append(allRecords, item.libdnsRecord())
+	}
+	return allRecords, nil
+}
+
+// listRecords retrieves all records of the service in API form, following
+// pagination. Names are made relative to zone.
+// ErrZoneNotFound is returned, wrapped, when the API doesn't know the
+// service of the zone, e.g. because the domain was removed or moved to
+// another account.
+var ErrZoneNotFound = errors.New("zone not found")
+
+func (p *Provider) listRecords(ctx context.Context, zone string) ([]apiRecord, error) {
+	var allRecords []apiRecord
 	page := 1
 
 	for {
 		urlPath := fmt.Sprintf("/service/%s/dns/record?page=%d&rowsPerPage=100", p.ServiceID, page)
-		sigPath := fmt.Sprintf("/v2/service/%s/dns/record", p.ServiceID)
 
-		req, err := http.NewRequestWithContext(ctx, "GET",
-			p.APIBase+urlPath, nil)
+		resp, err := p.doRequest(ctx, "GET", urlPath, nil)
 		if err != nil {
 			return nil, err
 		}
 
-		p.addAuthHeaders(req, "GET", sigPath)
-
-		resp, err := p.HTTPClient.Do(req)
-		if err != nil {
-			return nil, err
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: service %s of %s", ErrZoneNotFound, p.ServiceID, zone)
 		}
-
 		if resp.StatusCode != 200 {
 			bodyBytes, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
@@ -234,16 +579,10 @@ func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record
 
 		// Parse response JSON
 		var result struct {
-			CurrentPage  int `json:"currentPage"`
-			TotalPages   int `json:"totalPages"`
-			TotalRecords int `json:"totalRecords"`
-			Data         []struct {
-				ID      int    `json:"id"`
-				Name    string `json:"name"`
-				Type    string `json:"type"`
-				Content string `json:"content"`
-				TTL     int    `json:"ttl"`
-			} `json:"data"`
+			CurrentPage  int         `json:"currentPage"`
+			TotalPages   int         `json:"totalPages"`
+			TotalRecords int         `json:"totalRecords"`
+			Data         []apiRecord `json:"data"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 			resp.Body.Close()
@@ -252,20 +591,13 @@ func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record
 		resp.Body.Close()
 
 		for _, item := range result.Data {
-			// Only include TXT records
-			if item.Type != "TXT" {
-				continue
-			}
-
-			txtRec := &libdns.TXT{
-				Name:         item.Name,
-				Text:         item.Content,
-				TTL:          time.Duration(item.TTL) * time.Second,
-				ProviderData: fmt.Sprintf("%d", item.ID),
-			}
-			allRecords = append(allRecords, txtRec)
+			item.Name = relativeName(item.Name, zone)
+			allRecords = append(allRecords, item)
 		}
 
+		p.logger().DebugContext(ctx, "websupport: records page", slog.String("zone", zone),
+			slog.Int("page", page), slog.Int("total_pages", result.TotalPages), slog.Int("count", len(result.Data)))
+
 		// Check if there are more pages
 		if result.CurrentPage >= result.TotalPages {
 			break
@@ -273,5 +605,9 @@ func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record
 		page++
 	}
 
+	p.Metrics.observePages(page)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("websupport.pages", page))
+	p.logger().DebugContext(ctx, "websupport: records listed", slog.String("zone", zone),
+		slog.Int("pages", page), slog.Int("count", len(allRecords)))
 	return allRecords, nil
 }
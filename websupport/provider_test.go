@@ -0,0 +1,53 @@
+package websupport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+// testProvider returns a provider for example.com backed by h, usually an
+// in-memory API or a wrapper injecting failures into one.
+func testProvider(t *testing.T, h http.Handler) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return &Provider{APIKey: "key", APISecret: "secret", ServiceID: "1", APIBase: srv.URL}
+}
+
+// failing wraps api to answer the requests for which fail returns true
+// with 400 Bad Request instead of passing them on. rec is the decoded body
+// of POST and PUT requests.
+func failing(api http.Handler, fail func(r *http.Request, rec fakeapi.Record) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec fakeapi.Record
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &rec)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if fail(r, rec) {
+			http.Error(w, `{"message":"injected failure"}`, http.StatusBadRequest)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
+
+// zoneContents returns the zone's records as "name type content ttl"
+// strings, sorted, ignoring IDs.
+func zoneContents(api *fakeapi.API) []string {
+	var values []string
+	for _, rec := range api.Records() {
+		values = append(values, fmt.Sprintf("%s %s %s %d", rec.Name, rec.Type, rec.Content, rec.TTL))
+	}
+	slices.Sort(values)
+	return values
+}
@@ -0,0 +1,176 @@
+package websupport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit configures the client-side rate limiter of a provider.
+// Providers with the same API base, API key and RateLimit share one token
+// bucket, so a burst of ACME orders handled by several providers of one
+// account stays within the account's limit. Providers configured
+// differently have buckets of their own, and providers without a RateLimit
+// none; all of an account's providers are held back when the server says
+// its limit is exhausted.
+type RateLimit struct {
+	// RequestsPerSecond is the sustained request rate. Zero leaves the
+	// rate unlimited, so only the server's limit headers apply.
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+
+	// Burst is the number of requests that may be sent at once. Default: 1.
+	Burst int `json:"burst,omitempty"`
+
+	// MaxRetries is how often a request answered with 429 Too Many
+	// Requests is retried, after the Retry-After the server asked for (at
+	// most a minute). Default: 3; negative disables retries. Without a
+	// RateLimit, requests are not retried.
+	MaxRetries int `json:"max_retries,omitempty"`
+}
+
+// maxRetryAfter caps the pause a 429 response's Retry-After imposes, so
+// that a bogus header can't stall the account's requests indefinitely.
+const maxRetryAfter = time.Minute
+
+// accountLimiter queues the requests of a provider: by the token bucket of
+// its RateLimit, if it has one, and until the time the server said the
+// account's limit resets.
+type accountLimiter struct {
+	tokens *rate.Limiter // nil without a RateLimit
+	pause  *accountPause
+}
+
+// accountPause is when the requests of an account may be sent again.
+type accountPause struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+var limiters = struct {
+	sync.Mutex
+	tokens map[string]*rate.Limiter // by account and RateLimit
+	pauses map[string]*accountPause // by account
+}{tokens: make(map[string]*rate.Limiter), pauses: make(map[string]*accountPause)}
+
+// limiter returns p's limiter for apiKey: the token bucket shared by the
+// providers of the account with the same RateLimit, and the account's
+// pause.
+func (p *Provider) limiter(apiKey string) accountLimiter {
+	account := p.APIBase + " " + apiKey
+
+	limiters.Lock()
+	defer limiters.Unlock()
+	pause, ok := limiters.pauses[account]
+	if !ok {
+		pause = &accountPause{}
+		limiters.pauses[account] = pause
+	}
+	rl := p.RateLimit
+	if rl == nil {
+		return accountLimiter{pause: pause}
+	}
+
+	limit, burst := rate.Inf, 1
+	if rl.RequestsPerSecond > 0 {
+		limit = rate.Limit(rl.RequestsPerSecond)
+	}
+	if rl.Burst > 0 {
+		burst = rl.Burst
+	}
+	key := fmt.Sprintf("%s %v %d", account, limit, burst)
+	tokens, ok := limiters.tokens[key]
+	if !ok {
+		tokens = rate.NewLimiter(limit, burst)
+		limiters.tokens[key] = tokens
+	}
+	return accountLimiter{tokens: tokens, pause: pause}
+}
+
+// maxRetries returns how often requests answered with 429 are retried.
+func (p *Provider) maxRetries() int {
+	switch {
+	case p.RateLimit == nil:
+		return 0
+	case p.RateLimit.MaxRetries == 0:
+		return 3
+	default:
+		return max(p.RateLimit.MaxRetries, 0)
+	}
+}
+
+// wait blocks until a request may be sent or ctx ends.
+func (l accountLimiter) wait(ctx context.Context) error {
+	l.pause.mu.Lock()
+	pause := time.Until(l.pause.until)
+	l.pause.mu.Unlock()
+	if pause > 0 {
+		timer := time.NewTimer(pause)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if l.tokens == nil {
+		return nil
+	}
+	return l.tokens.Wait(ctx)
+}
+
+// observe pauses the account's requests as the response's headers ask:
+// until X-RateLimit-Reset when X-RateLimit-Remaining is 0, or for
+// Retry-After (default: 1 second, at most maxRetryAfter) on 429 Too Many
+// Requests.
+func (l accountLimiter) observe(resp *http.Response) {
+	var until time.Time
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		until = parseReset(resp.Header.Get("X-RateLimit-Reset"))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retry := parseReset(resp.Header.Get("Retry-After"))
+		if retry.IsZero() {
+			retry = time.Now().Add(time.Second)
+		}
+		if limit := time.Now().Add(maxRetryAfter); retry.After(limit) {
+			retry = limit
+		}
+		until = later(until, retry)
+	}
+	if until.IsZero() {
+		return
+	}
+	l.pause.mu.Lock()
+	l.pause.until = later(l.pause.until, until)
+	l.pause.mu.Unlock()
+}
+
+// parseReset parses a reset time given as seconds from now, a Unix time
+// or an HTTP date. It returns the zero time if v is none of these.
+func parseReset(v string) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n > 1e9 {
+			return time.Unix(n, 0)
+		}
+		return time.Now().Add(time.Duration(n) * time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
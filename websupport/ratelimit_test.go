@@ -0,0 +1,134 @@
+package websupport
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statuses answers requests with codes in turn, then with 200 OK, and
+// counts the requests.
+func statuses(n *atomic.Int32, header http.Header, codes ...int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(n.Add(1)) - 1
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		if i < len(codes) {
+			w.WriteHeader(codes[i])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestRetriesAreOptIn(t *testing.T) {
+	retryNow := http.Header{"Retry-After": {"0"}}
+	for _, tt := range []struct {
+		name      string
+		rateLimit *RateLimit
+		want      int // status
+		requests  int32
+	}{
+		{"without RateLimit", nil, http.StatusTooManyRequests, 1},
+		{"default retries", &RateLimit{}, http.StatusOK, 3},
+		{"too few retries", &RateLimit{MaxRetries: 1}, http.StatusTooManyRequests, 2},
+		{"retries disabled", &RateLimit{MaxRetries: -1}, http.StatusTooManyRequests, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var n atomic.Int32
+			p := testProvider(t, statuses(&n, retryNow, http.StatusTooManyRequests, http.StatusTooManyRequests))
+			p.RateLimit = tt.rateLimit
+			p.ensureClient()
+			resp, err := p.doRequest(context.Background(), "GET", "/service/1/dns/record", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want || n.Load() != tt.requests {
+				t.Errorf("status %d after %d requests, want %d after %d", resp.StatusCode, n.Load(), tt.want, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	var n atomic.Int32
+	p := testProvider(t, statuses(&n, http.Header{"Retry-After": {"86400"}}, http.StatusTooManyRequests))
+	p.ensureClient()
+	resp, err := p.doRequest(context.Background(), "GET", "/service/1/dns/record", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	l := p.limiter("key")
+	l.pause.mu.Lock()
+	pause := time.Until(l.pause.until)
+	l.pause.mu.Unlock()
+	if pause <= 0 || pause > maxRetryAfter {
+		t.Errorf("paused for %v, want at most %v", pause, maxRetryAfter)
+	}
+}
+
+func TestLimiterKeyedByConfig(t *testing.T) {
+	base := "https://limiter.test/" + t.Name()
+	slow := &Provider{APIBase: base, RateLimit: &RateLimit{RequestsPerSecond: 1}}
+	slow2 := &Provider{APIBase: base, RateLimit: &RateLimit{RequestsPerSecond: 1}}
+	fast := &Provider{APIBase: base, RateLimit: &RateLimit{RequestsPerSecond: 100, Burst: 10}}
+	unlimited := &Provider{APIBase: base}
+
+	a, b, c, d := slow.limiter("key"), slow2.limiter("key"), fast.limiter("key"), unlimited.limiter("key")
+	if a.tokens != b.tokens {
+		t.Error("providers with the same RateLimit don't share a bucket")
+	}
+	if c.tokens == a.tokens || c.tokens.Limit() != 100 || c.tokens.Burst() != 10 {
+		t.Errorf("provider with another RateLimit got limit %v, burst %d", c.tokens.Limit(), c.tokens.Burst())
+	}
+	if a.tokens.Limit() != 1 {
+		t.Errorf("the other provider changed the limit to %v", a.tokens.Limit())
+	}
+	if d.tokens != nil {
+		t.Error("provider without a RateLimit got a bucket")
+	}
+	if a.pause != c.pause || a.pause != d.pause {
+		t.Error("providers of one account don't share the server's pause")
+	}
+	if other := slow.limiter("other"); other.pause == a.pause || other.tokens == a.tokens {
+		t.Error("providers of different accounts share a limiter")
+	}
+}
+
+// countingSource returns the same credentials and counts the calls.
+type countingSource struct{ calls atomic.Int32 }
+
+func (s *countingSource) Credentials(ctx context.Context) (Credentials, error) {
+	s.calls.Add(1)
+	return Credentials{APIKey: "key", APISecret: "secret"}, nil
+}
+
+func TestCredentialReloadOnce(t *testing.T) {
+	var n atomic.Int32
+	p := testProvider(t, statuses(&n, http.Header{"Retry-After": {"0"}},
+		http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusUnauthorized))
+	source := &countingSource{}
+	p.CredentialSource = source
+	p.RateLimit = &RateLimit{}
+	p.ensureClient()
+
+	resp, err := p.doRequest(context.Background(), "GET", "/service/1/dns/record", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// Read once, re-read once after the first 401, and not again for the
+	// 429 retries or the second 401, which is returned.
+	if resp.StatusCode != http.StatusUnauthorized || n.Load() != 4 {
+		t.Errorf("status %d after %d requests, want 401 after 4", resp.StatusCode, n.Load())
+	}
+	if calls := source.calls.Load(); calls != 2 {
+		t.Errorf("credential source called %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,223 @@
+package websupport
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// apiRecord is a DNS record as represented by the Websupport REST API.
+type apiRecord struct {
+	ID      int    `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+	Prio    int    `json:"prio,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+// newAPIRecord converts a libdns record into the API representation, with
+// the name relative to zone.
+func newAPIRecord(rec libdns.Record, zone string) apiRecord {
+	rr := rec.RR()
+	a := apiRecord{
+		Name:    relativeName(rr.Name, zone),
+		Type:    rr.Type,
+		Content: rr.Data,
+		TTL:     int(rr.TTL.Seconds()),
+	}
+	parsed, err := rr.Parse()
+	if err != nil {
+		return a
+	}
+	switch r := parsed.(type) {
+	case libdns.MX:
+		a.Prio = int(r.Preference)
+		a.Content = r.Target
+	case libdns.SRV:
+		a.Prio = int(r.Priority)
+		a.Weight = int(r.Weight)
+		a.Port = int(r.Port)
+		a.Content = r.Target
+	}
+	return a
+}
+
+// validate checks that the record can be submitted to the API.
+func (a apiRecord) validate() error {
+	if a.Type == "" {
+		return fmt.Errorf("record %q has no type", a.Name)
+	}
+	if a.Content == "" {
+		return fmt.Errorf("record %s %s has no content", a.Name, a.Type)
+	}
+	return nil
+}
+
+// relativeName returns name relative to zone, with "@" for the apex.
+func relativeName(name, zone string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || strings.EqualFold(name, zone) {
+		return "@"
+	}
+	if zone != "" && len(name) > len(zone) && strings.EqualFold(name[len(name)-len(zone)-1:], "."+zone) {
+		return name[:len(name)-len(zone)-1]
+	}
+	return name
+}
+
+// sameContent reports whether two records have the same name, type and
+// content, ignoring TTL, ID and trailing dots of host names.
+func (a apiRecord) sameContent(b apiRecord) bool {
+	return strings.EqualFold(a.Name, b.Name) &&
+		strings.EqualFold(a.Type, b.Type) &&
+		strings.TrimSuffix(a.Content, ".") == strings.TrimSuffix(b.Content, ".") &&
+		a.Prio == b.Prio && a.Weight == b.Weight && a.Port == b.Port
+}
+
+// findAPIRecord returns the first record in recs with the same content as
+// want.
+func findAPIRecord(recs []apiRecord, want apiRecord) (apiRecord, bool) {
+	for _, rec := range recs {
+		if rec.sameContent(want) {
+			return rec, true
+		}
+	}
+	return apiRecord{}, false
+}
+
+// findNewAPIRecord returns the first record in recs with the same content
+// as want whose ID is not in seen, and adds its ID to seen. Seeding seen
+// with the records listed before a create tells the created record apart
+// from identical ones that already existed.
+func findNewAPIRecord(recs []apiRecord, seen map[int]bool, want apiRecord) (apiRecord, bool) {
+	for _, rec := range recs {
+		if !seen[rec.ID] && rec.sameContent(want) {
+			seen[rec.ID] = true
+			return rec, true
+		}
+	}
+	return apiRecord{}, false
+}
+
+// findAPIRecordByID returns the record in recs with the given ID.
+func findAPIRecordByID(recs []apiRecord, id string) (apiRecord, bool) {
+	for _, rec := range recs {
+		if id != "" && fmt.Sprintf("%d", rec.ID) == id {
+			return rec, true
+		}
+	}
+	return apiRecord{}, false
+}
+
+// rr returns the record in libdns' generic representation. Priority, weight
+// and port, which Websupport keeps in separate fields, are folded back into
+// the RDATA so that the result can be parsed by libdns.
+func (a apiRecord) rr() libdns.RR {
+	data := a.Content
+	switch a.Type {
+	case "MX":
+		data = fmt.Sprintf("%d %s", a.Prio, a.Content)
+	case "SRV":
+		data = fmt.Sprintf("%d %d %d %s", a.Prio, a.Weight, a.Port, a.Content)
+	}
+	return libdns.RR{
+		Name: a.Name,
+		TTL:  time.Duration(a.TTL) * time.Second,
+		Type: a.Type,
+		Data: data,
+	}
+}
+
+// libdnsRecord converts the API record to the most specific libdns type
+// available. The Websupport record ID, if known, is stored in ProviderData
+// as a string.
+// Records which libdns cannot parse are returned as a plain libdns.RR, which
+// cannot carry an ID.
+func (a apiRecord) libdnsRecord() libdns.Record {
+	rr := a.rr()
+	parsed, err := rr.Parse()
+	if err != nil {
+		return rr
+	}
+	if a.ID == 0 {
+		return parsed
+	}
+	return WithRecordID(parsed, fmt.Sprintf("%d", a.ID))
+}
+
+// WithRecordID stores id in the ProviderData of rec. Pointers to libdns
+// record types are updated in place; for values a pointer to an updated copy
+// is returned. Records which cannot carry an ID are returned unchanged.
+func WithRecordID(rec libdns.Record, id string) libdns.Record {
+	if id == "" {
+		return rec
+	}
+	switch r := rec.(type) {
+	case *libdns.Address:
+		r.ProviderData = id
+	case *libdns.CAA:
+		r.ProviderData = id
+	case *libdns.CNAME:
+		r.ProviderData = id
+	case *libdns.MX:
+		r.ProviderData = id
+	case *libdns.NS:
+		r.ProviderData = id
+	case *libdns.SRV:
+		r.ProviderData = id
+	case *libdns.ServiceBinding:
+		r.ProviderData = id
+	case *libdns.TXT:
+		r.ProviderData = id
+	case libdns.Address:
+		return WithRecordID(&r, id)
+	case libdns.CAA:
+		return WithRecordID(&r, id)
+	case libdns.CNAME:
+		return WithRecordID(&r, id)
+	case libdns.MX:
+		return WithRecordID(&r, id)
+	case libdns.NS:
+		return WithRecordID(&r, id)
+	case libdns.SRV:
+		return WithRecordID(&r, id)
+	case libdns.ServiceBinding:
+		return WithRecordID(&r, id)
+	case libdns.TXT:
+		return WithRecordID(&r, id)
+	}
+	return rec
+}
+
+// RecordID returns the Websupport record ID stored in rec's ProviderData,
+// or "" if rec does not carry one.
+func RecordID(rec libdns.Record) string {
+	var data any
+	switch r := rec.(type) {
+	case *libdns.Address:
+		data = r.ProviderData
+	case *libdns.CAA:
+		data = r.ProviderData
+	case *libdns.CNAME:
+		data = r.ProviderData
+	case *libdns.MX:
+		data = r.ProviderData
+	case *libdns.NS:
+		data = r.ProviderData
+	case *libdns.SRV:
+		data = r.ProviderData
+	case *libdns.ServiceBinding:
+		data = r.ProviderData
+	case *libdns.TXT:
+		data = r.ProviderData
+	}
+	id, _ := data.(string)
+	return id
+}
@@ -0,0 +1,51 @@
+package websupport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Service is a service of a Websupport account, such as a domain.
+type Service struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`        // the domain name of domain services
+	ServiceName string `json:"serviceName"` // the kind of service, e.g. "domain"
+}
+
+// ListServices returns the services of the account, following pagination.
+// ServiceID need not be set.
+func (p *Provider) ListServices(ctx context.Context) ([]Service, error) {
+	p.ensureClient()
+
+	var services []Service
+	for page := 1; ; page++ {
+		resp, err := p.doRequest(ctx, "GET", fmt.Sprintf("/service?page=%d&rowsPerPage=100", page), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to list services: %s, body: %s", resp.Status, string(bodyBytes))
+		}
+
+		var result struct {
+			CurrentPage int       `json:"currentPage"`
+			TotalPages  int       `json:"totalPages"`
+			Data        []Service `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+		resp.Body.Close()
+
+		services = append(services, result.Data...)
+		if result.CurrentPage >= result.TotalPages {
+			return services, nil
+		}
+	}
+}
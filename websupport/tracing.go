@@ -0,0 +1,105 @@
+package websupport
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/libdns/libdns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	otelpropagation "go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/libdns/websupport/websupport"
+
+// traceContext propagates the span of each API request to the API in the
+// W3C traceparent and tracestate headers.
+var traceContext = otelpropagation.TraceContext{}
+
+// tracer returns the tracer of TracerProvider, or one that records
+// nothing.
+func (p *Provider) tracer() trace.Tracer {
+	if p.TracerProvider != nil {
+		return p.TracerProvider.Tracer(tracerName)
+	}
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+// startOperation starts the span of a libdns method, as a child of the
+// span in ctx if there is one.
+func (p *Provider) startOperation(ctx context.Context, name, zone string, recs []libdns.Record) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("dns.zone", zone),
+		attribute.String("websupport.service_id", p.ServiceID),
+	}
+	if recs != nil {
+		var types []string
+		seen := make(map[string]bool)
+		for _, rec := range recs {
+			if typ := rec.RR().Type; !seen[typ] {
+				seen[typ] = true
+				types = append(types, typ)
+			}
+		}
+		attrs = append(attrs,
+			attribute.StringSlice("dns.record.types", types),
+			attribute.Int("dns.record.count", len(recs)))
+	}
+	if p.DryRun {
+		attrs = append(attrs, attribute.Bool("websupport.dry_run", true))
+	}
+	return p.tracer().Start(ctx, "websupport."+name, trace.WithAttributes(attrs...))
+}
+
+// endOperation ends the span of a libdns method that returned n records
+// and err.
+func endOperation(span trace.Span, n int, err error) {
+	span.SetAttributes(attribute.Int("dns.result.count", n))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startRequest starts the client span of an API request and injects its
+// trace context into the request headers. It returns req with the span's
+// context, so that instrumented transports and logs see the span. The page
+// of record listings is recorded as an attribute.
+func (p *Provider) startRequest(req *http.Request, path string, attempt int) (*http.Request, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", req.URL.String()),
+		attribute.String("url.template", endpoint(path)),
+		attribute.String("server.address", req.URL.Hostname()),
+	}
+	if attempt > 1 {
+		attrs = append(attrs, attribute.Int("http.request.resend_count", attempt-1))
+	}
+	if page, err := strconv.Atoi(req.URL.Query().Get("page")); err == nil {
+		attrs = append(attrs, attribute.Int("websupport.page", page))
+	}
+	ctx, span := p.tracer().Start(req.Context(), req.Method+" "+endpoint(path),
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	traceContext.Inject(ctx, otelpropagation.HeaderCarrier(req.Header))
+	return req.WithContext(ctx), span
+}
+
+// endRequest ends the span of an API request. Error statuses mark the
+// span as failed.
+func endRequest(span trace.Span, resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case resp.StatusCode >= 400:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		span.SetStatus(codes.Error, resp.Status)
+	default:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	span.End()
+}
@@ -0,0 +1,77 @@
+package websupport
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	otelpropagation "go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+// idTracerProvider starts spans that record nothing but have span
+// contexts of their own, so tests can tell them apart.
+type idTracerProvider struct {
+	noop.TracerProvider
+	next atomic.Uint64
+}
+
+func (tp *idTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return idTracer{tp: tp}
+}
+
+type idTracer struct {
+	noop.Tracer
+	tp *idTracerProvider
+}
+
+func (t idTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	n := t.tp.next.Add(1)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{byte(n >> 8), byte(n)},
+		TraceFlags: trace.FlagsSampled,
+	})
+	span := idSpan{sc: sc}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type idSpan struct {
+	noop.Span
+	sc trace.SpanContext
+}
+
+func (s idSpan) SpanContext() trace.SpanContext { return s.sc }
+
+// transportFunc is an http.RoundTripper calling a function.
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRequestCarriesSpanContext(t *testing.T) {
+	p := testProvider(t, fakeapi.New())
+	p.TracerProvider = &idTracerProvider{}
+	var checked int
+	p.HTTPClient = &http.Client{Transport: transportFunc(func(req *http.Request) (*http.Response, error) {
+		// The transport sees the span of the request, the one the
+		// traceparent header names.
+		got := trace.SpanContextFromContext(req.Context())
+		sent := trace.SpanContextFromContext(otelpropagation.TraceContext{}.Extract(context.Background(), otelpropagation.HeaderCarrier(req.Header)))
+		if !got.IsValid() || got.SpanID() != sent.SpanID() {
+			t.Errorf("request context has span %v, traceparent names %v", got.SpanID(), sent.SpanID())
+		}
+		checked++
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+
+	if _, err := p.GetRecords(context.Background(), "example.com."); err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatal("no request was sent")
+	}
+}
@@ -0,0 +1,461 @@
+package websupport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// Transaction collects changes to the records of one zone and applies them
+// with Commit, all or none: if a change fails, the changes already made
+// are undone.
+type Transaction struct {
+	p       *Provider
+	zone    string
+	appends []libdns.Record
+	deletes []libdns.Record
+	sets    []libdns.Record
+}
+
+// Begin starts a transaction on zone.
+func (p *Provider) Begin(zone string) *Transaction {
+	return &Transaction{p: p, zone: zone}
+}
+
+// Append adds records to be created.
+func (t *Transaction) Append(recs ...libdns.Record) {
+	t.appends = append(t.appends, recs...)
+}
+
+// Delete adds records to be deleted, identified by ID or by name, type and
+// content. Records which don't exist are skipped.
+func (t *Transaction) Delete(recs ...libdns.Record) {
+	t.deletes = append(t.deletes, recs...)
+}
+
+// Set adds records whose RRsets (the records of the same name and type)
+// are to be replaced: records of the set that exist are kept, with their
+// TTL updated if it differs, missing ones are created and the others of
+// the RRset are deleted. A single record replacing a single other one,
+// such as a CNAME, is updated in place.
+func (t *Transaction) Set(recs ...libdns.Record) {
+	t.sets = append(t.sets, recs...)
+}
+
+// TransactionResult lists the changes a transaction made to the zone.
+type TransactionResult struct {
+	Created []libdns.Record
+	Updated []libdns.Record
+	Deleted []libdns.Record
+}
+
+// TransactionError is returned by Commit when a change failed. The changes
+// made before it were undone, unless RollbackErr says otherwise.
+type TransactionError struct {
+	Zone        string
+	Err         error // the change that failed
+	Undone      int   // changes undone
+	RollbackErr error // why some changes could not be undone
+}
+
+func (e *TransactionError) Error() string {
+	msg := fmt.Sprintf("transaction on %s failed: %v", strings.TrimSuffix(e.Zone, "."), e.Err)
+	if e.RollbackErr != nil {
+		return msg + fmt.Sprintf("; rollback failed after undoing %d changes, the zone is left partially changed: %v", e.Undone, e.RollbackErr)
+	}
+	return msg + fmt.Sprintf("; rolled back %d changes", e.Undone)
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// update is a planned change of a record from old to new.
+type update struct {
+	old, new apiRecord
+}
+
+// txPlan is what a transaction does to the zone, in the order it is
+// applied: records are created before the records they replace are
+// deleted, so a name never goes without records.
+type txPlan struct {
+	creates []apiRecord
+	updates []update
+	deletes []apiRecord
+}
+
+// Commit snapshots the records affected by the transaction, checks all
+// changes against validation and the protection policy, and applies them:
+// creations first, then updates, then deletions. If a change fails, the
+// changes made so far are undone in reverse order and a
+// *TransactionError reports both the failure and the outcome of the
+// rollback. In dry-run mode, the changes are planned only.
+func (t *Transaction) Commit(ctx context.Context) (*TransactionResult, error) {
+	p := t.p
+	ctx, span := p.startOperation(ctx, "Commit", t.zone, t.records())
+	result, err := t.commit(ctx)
+	n := 0
+	if result != nil {
+		n = len(result.Created) + len(result.Updated) + len(result.Deleted)
+	}
+	endOperation(span, n, err)
+	return result, err
+}
+
+func (t *Transaction) records() []libdns.Record {
+	var recs []libdns.Record
+	recs = append(recs, t.appends...)
+	recs = append(recs, t.sets...)
+	return append(recs, t.deletes...)
+}
+
+func (t *Transaction) commit(ctx context.Context) (*TransactionResult, error) {
+	p := t.p
+	p.ensureClient()
+
+	if p.ServiceID == "" {
+		return nil, fmt.Errorf("ServiceID is required - set WEBSUPPORT_SERVICE_ID environment variable")
+	}
+
+	snapshot, err := p.listRecords(ctx, t.zone)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := t.plan(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("/service/%s/dns/record", p.ServiceID)
+	if p.DryRun {
+		for _, item := range plan.creates {
+			p.planOperation(ctx, Operation{Action: "create", Zone: t.zone, Method: "POST", Path: path, Record: item.rr(), Body: item})
+		}
+		for _, u := range plan.updates {
+			id := fmt.Sprint(u.old.ID)
+			p.planOperation(ctx, Operation{Action: "update", Zone: t.zone, Method: "PUT", Path: path + "/" + id, RecordID: id, Record: u.new.rr(), Body: u.new})
+		}
+		for _, item := range plan.deletes {
+			id := fmt.Sprint(item.ID)
+			p.planOperation(ctx, Operation{Action: "delete", Zone: t.zone, Method: "DELETE", Path: path + "/" + id, RecordID: id, Record: item.rr()})
+		}
+		return plan.result(nil), nil
+	}
+
+	var done txPlan
+	if err := t.apply(ctx, plan, &done); err != nil {
+		p.logger().WarnContext(ctx, "websupport: transaction failed, rolling back", slog.String("zone", t.zone), slog.Any("error", err))
+		undone, rollbackErr := t.rollback(ctx, snapshot, done)
+		p.logger().InfoContext(ctx, "websupport: transaction rolled back", slog.String("zone", t.zone),
+			slog.Int("undone", undone), slog.Any("error", rollbackErr))
+		return nil, &TransactionError{Zone: t.zone, Err: err, Undone: undone, RollbackErr: rollbackErr}
+	}
+
+	// The API doesn't return the IDs of new records; one listing after
+	// the changes finds them.
+	var current []apiRecord
+	if len(done.creates) > 0 {
+		time.Sleep(1 * time.Second) // Give DNS time to propagate
+		current, _ = p.listRecords(ctx, t.zone)
+	}
+	p.logger().InfoContext(ctx, "websupport: transaction committed", slog.String("zone", t.zone),
+		slog.Int("created", len(done.creates)), slog.Int("updated", len(done.updates)), slog.Int("deleted", len(done.deletes)))
+	return done.result(newRecords(current, snapshot, done.creates)), nil
+}
+
+// plan works out the changes of the transaction against the records of the
+// zone, and checks them before anything is changed.
+func (t *Transaction) plan(snapshot []apiRecord) (txPlan, error) {
+	var plan txPlan
+	p := t.p
+	deleting := make(map[int]bool)
+
+	for _, rec := range t.appends {
+		item := createItem(rec, t.zone)
+		if err := item.validate(); err != nil {
+			return plan, err
+		}
+		if err := p.checkProtection("create", t.zone, item); err != nil {
+			return plan, err
+		}
+		plan.creates = append(plan.creates, item)
+	}
+
+	// Group the records to set by RRset, in the order they were given.
+	type rrset struct{ name, typ string }
+	var sets []rrset
+	want := make(map[rrset][]apiRecord)
+	for _, rec := range t.sets {
+		item := createItem(rec, t.zone)
+		if err := item.validate(); err != nil {
+			return plan, err
+		}
+		key := rrset{strings.ToLower(item.Name), strings.ToUpper(item.Type)}
+		if _, ok := want[key]; !ok {
+			sets = append(sets, key)
+		}
+		want[key] = append(want[key], item)
+	}
+	for _, key := range sets {
+		kept := make(map[int]bool)
+		var missing, stale []apiRecord
+		for _, item := range want[key] {
+			found := false
+			for _, cur := range snapshot {
+				if kept[cur.ID] || !cur.sameContent(item) {
+					continue
+				}
+				kept[cur.ID] = true
+				found = true
+				if item.TTL != 0 && item.TTL != cur.TTL {
+					if err := t.planUpdate(&plan, cur, item); err != nil {
+						return plan, err
+					}
+				}
+				break
+			}
+			if !found {
+				missing = append(missing, item)
+			}
+		}
+		for _, cur := range snapshot {
+			if strings.EqualFold(cur.Name, key.name) && strings.EqualFold(cur.Type, key.typ) && !kept[cur.ID] && !deleting[cur.ID] {
+				stale = append(stale, cur)
+			}
+		}
+
+		// A record replacing the only other record of its RRset is
+		// updated in place: a CNAME or the apex SOA can't be created
+		// next to the record it replaces, nor deleted first.
+		if len(missing) == 1 && len(stale) == 1 {
+			if err := t.planUpdate(&plan, stale[0], missing[0]); err != nil {
+				return plan, err
+			}
+			deleting[stale[0].ID] = true
+			continue
+		}
+		for _, item := range missing {
+			if err := p.checkProtection("create", t.zone, item); err != nil {
+				return plan, err
+			}
+			plan.creates = append(plan.creates, item)
+		}
+		for _, cur := range stale {
+			if err := p.checkProtection("delete", t.zone, cur); err != nil {
+				return plan, err
+			}
+			deleting[cur.ID] = true
+			plan.deletes = append(plan.deletes, cur)
+		}
+	}
+
+	for _, rec := range t.deletes {
+		found, ok := findAPIRecordByID(snapshot, RecordID(rec))
+		if !ok {
+			found, ok = findAPIRecord(snapshot, newAPIRecord(rec, t.zone))
+		}
+		if !ok || deleting[found.ID] {
+			continue
+		}
+		if err := p.checkProtection("delete", t.zone, found); err != nil {
+			return plan, err
+		}
+		deleting[found.ID] = true
+		plan.deletes = append(plan.deletes, found)
+	}
+	return plan, nil
+}
+
+// planUpdate adds the change of cur to item to plan, keeping the note of
+// cur, if the protection policy allows changing both.
+func (t *Transaction) planUpdate(plan *txPlan, cur, item apiRecord) error {
+	item.Note = cur.Note
+	if err := t.p.checkProtection("update", t.zone, cur); err != nil {
+		return err
+	}
+	if err := t.p.checkProtection("update", t.zone, item); err != nil {
+		return err
+	}
+	plan.updates = append(plan.updates, update{old: cur, new: item})
+	return nil
+}
+
+// apply makes the changes of plan, recording those that succeeded in done.
+// It stops at the first failure.
+func (t *Transaction) apply(ctx context.Context, plan txPlan, done *txPlan) error {
+	p := t.p
+	errs := p.runBatch(ctx, len(plan.creates), true, func(i int) error {
+		return p.createRecord(ctx, plan.creates[i])
+	})
+	for i, err := range errs {
+		if err == nil {
+			done.creates = append(done.creates, plan.creates[i])
+			p.Metrics.observeRecord("created", plan.creates[i].Type)
+		}
+	}
+	if err := firstRecordError(errs, plan.creates); err != nil {
+		return err
+	}
+
+	for _, u := range plan.updates {
+		if err := p.updateRecordByID(ctx, fmt.Sprint(u.old.ID), u.new); err != nil {
+			return RecordError{Record: u.new.rr(), Err: err}
+		}
+		done.updates = append(done.updates, u)
+		p.Metrics.observeRecord("updated", u.new.Type)
+	}
+
+	errs = p.runBatch(ctx, len(plan.deletes), true, func(i int) error {
+		return p.deleteRecord(ctx, fmt.Sprint(plan.deletes[i].ID))
+	})
+	for i, err := range errs {
+		if err == nil {
+			done.deletes = append(done.deletes, plan.deletes[i])
+			p.Metrics.observeRecord("deleted", plan.deletes[i].Type)
+		}
+	}
+	return firstRecordError(errs, plan.deletes)
+}
+
+// rollback undoes done in reverse order: deleted records are created again
+// (with new IDs), updated records are reverted and created records are
+// deleted. It returns the number of changes undone.
+func (t *Transaction) rollback(ctx context.Context, snapshot []apiRecord, done txPlan) (int, error) {
+	p := t.p
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	undone := 0
+	var errs []error
+	for _, item := range done.deletes {
+		item.ID = 0
+		if err := p.createRecord(ctx, item); err != nil {
+			errs = append(errs, RecordError{Record: item.rr(), Err: fmt.Errorf("failed to recreate deleted record: %v", err)})
+			continue
+		}
+		undone++
+		p.Metrics.observeRecord("created", item.Type)
+	}
+	for i := len(done.updates) - 1; i >= 0; i-- {
+		u := done.updates[i]
+		if err := p.updateRecordByID(ctx, fmt.Sprint(u.old.ID), u.old); err != nil {
+			errs = append(errs, RecordError{Record: u.old.rr(), Err: fmt.Errorf("failed to revert updated record: %v", err)})
+			continue
+		}
+		undone++
+		p.Metrics.observeRecord("updated", u.old.Type)
+	}
+	if len(done.creates) > 0 {
+		current, err := p.listRecords(ctx, t.zone)
+		if err != nil {
+			return undone, errors.Join(append(errs, fmt.Errorf("failed to find created records to delete: %v", err))...)
+		}
+		created := newRecords(current, snapshot, done.creates)
+		for i, item := range done.creates {
+			id := RecordID(created[i])
+			if id == "" {
+				errs = append(errs, RecordError{Record: item.rr(), Err: errors.New("created record not found to delete")})
+				continue
+			}
+			if err := p.deleteRecord(ctx, id); err != nil {
+				errs = append(errs, RecordError{Record: item.rr(), Err: fmt.Errorf("failed to delete created record: %v", err)})
+				continue
+			}
+			undone++
+			p.Metrics.observeRecord("deleted", item.Type)
+		}
+	}
+	return undone, errors.Join(errs...)
+}
+
+// result returns the changes of plan as libdns records; created are the
+// created records with their IDs, if known.
+func (plan txPlan) result(created []libdns.Record) *TransactionResult {
+	r := &TransactionResult{Created: created}
+	if created == nil {
+		for _, item := range plan.creates {
+			r.Created = append(r.Created, item.libdnsRecord())
+		}
+	}
+	for _, u := range plan.updates {
+		rec := u.new
+		rec.ID = u.old.ID
+		r.Updated = append(r.Updated, rec.libdnsRecord())
+	}
+	for _, item := range plan.deletes {
+		r.Deleted = append(r.Deleted, item.libdnsRecord())
+	}
+	return r
+}
+
+// newRecords returns the records of current that match created and did
+// not exist in snapshot, in the order of created. Records that are not
+// found are returned without an ID.
+func newRecords(current, snapshot []apiRecord, created []apiRecord) []libdns.Record {
+	old := make(map[int]bool)
+	for _, rec := range snapshot {
+		old[rec.ID] = true
+	}
+	recs := make([]libdns.Record, len(created))
+	for i, item := range created {
+		recs[i] = item.libdnsRecord()
+		if cur, ok := findNewAPIRecord(current, old, item); ok {
+			recs[i] = cur.libdnsRecord()
+		}
+	}
+	return recs
+}
+
+// firstRecordError returns the first error of errs, other than for
+// skipped records, as a RecordError.
+func firstRecordError(errs []error, items []apiRecord) error {
+	for i, err := range errs {
+		if err != nil && err != errSkipped {
+			return RecordError{Record: items[i].rr(), Err: err}
+		}
+	}
+	return nil
+}
+
+// createItem converts a record to be created into the API
+// representation. TXT records without a TTL get 120 seconds.
+func createItem(rec libdns.Record, zone string) apiRecord {
+	item := newAPIRecord(rec, zone)
+	if item.Type == "TXT" && item.TTL == 0 {
+		item.TTL = 120
+	}
+	return item
+}
+
+// SetRecords implements libdns.RecordSetter: the RRsets of recs are
+// replaced by recs in one transaction (see Transaction.Set), so that on
+// failure the zone is left as it was.
+func (p *Provider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	tx := p.Begin(zone)
+	tx.Set(recs...)
+	result, err := tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Created and updated records are returned with their IDs, the
+	// others as given.
+	changed := slices.Concat(result.Created, result.Updated)
+	set := make([]libdns.Record, len(recs))
+	for i, rec := range recs {
+		set[i] = rec
+		item := createItem(rec, zone)
+		for _, c := range changed {
+			if createItem(c, zone).sameContent(item) {
+				set[i] = c
+				break
+			}
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,205 @@
+package websupport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/websupport/internal/fakeapi"
+)
+
+// txZone returns an API holding the records the transaction tests change.
+func txZone() *fakeapi.API {
+	return fakeapi.New(
+		fakeapi.Record{Name: "www", Type: "A", Content: "1.1.1.1", TTL: 600},
+		fakeapi.Record{Name: "old", Type: "A", Content: "2.2.2.2", TTL: 600},
+		fakeapi.Record{Name: "@", Type: "MX", Content: "mail.example.com", Prio: 10, TTL: 600},
+	)
+}
+
+// txChanges adds a creation, an update and a deletion to tx.
+func txChanges(tx *Transaction) {
+	tx.Append(libdns.TXT{Name: "_acme-challenge", Text: "token"})
+	tx.Set(libdns.Address{Name: "www", IP: netip.MustParseAddr("1.1.1.1"), TTL: 300 * time.Second})
+	tx.Delete(libdns.Address{Name: "old", IP: netip.MustParseAddr("2.2.2.2")})
+}
+
+func TestTransactionCommit(t *testing.T) {
+	api := txZone()
+	tx := testProvider(t, api).Begin("example.com.")
+	txChanges(tx)
+
+	result, err := tx.Commit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 1 || RecordID(result.Created[0]) != "1004" {
+		t.Errorf("created %v, want the TXT record with ID 1004", result.Created)
+	}
+	if len(result.Updated) != 1 || RecordID(result.Updated[0]) != "1001" || result.Updated[0].RR().TTL != 300*time.Second {
+		t.Errorf("updated %v, want www with TTL 300", result.Updated)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0].RR().Name != "old" {
+		t.Errorf("deleted %v, want old", result.Deleted)
+	}
+
+	var methods []string
+	for _, req := range api.Requests() {
+		methods = append(methods, req.Method)
+	}
+	if !slices.Equal(methods, []string{"POST", "PUT", "DELETE"}) {
+		t.Errorf("requests %v, want create, update, delete in that order", methods)
+	}
+	want := []string{"@ MX mail.example.com 600", "_acme-challenge TXT token 120", "www A 1.1.1.1 300"}
+	if got := zoneContents(api); !slices.Equal(got, want) {
+		t.Errorf("zone %v, want %v", got, want)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	api := txZone()
+	before := zoneContents(api)
+	p := testProvider(t, failing(api, func(r *http.Request, rec fakeapi.Record) bool {
+		return r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/1002")
+	}))
+	tx := p.Begin("example.com.")
+	txChanges(tx)
+
+	result, err := tx.Commit(context.Background())
+	var terr *TransactionError
+	if !errors.As(err, &terr) {
+		t.Fatalf("error %v, want a *TransactionError", err)
+	}
+	if result != nil {
+		t.Errorf("result %+v, want none", result)
+	}
+	if terr.Undone != 2 || terr.RollbackErr != nil {
+		t.Errorf("undone %d (%v), want the creation and the update undone", terr.Undone, terr.RollbackErr)
+	}
+	var rerr RecordError
+	if !errors.As(err, &rerr) || rerr.Record.Name != "old" {
+		t.Errorf("errors.As found %v, want the RecordError of old", rerr)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, `transaction on example.com failed: old A "2.2.2.2": `) || !strings.HasSuffix(msg, "; rolled back 2 changes") {
+		t.Errorf("message %q", msg)
+	}
+	if got := zoneContents(api); !slices.Equal(got, before) {
+		t.Errorf("zone %v, want it as before: %v", got, before)
+	}
+}
+
+func TestTransactionRollbackFails(t *testing.T) {
+	api := txZone()
+	p := testProvider(t, failing(api, func(r *http.Request, rec fakeapi.Record) bool {
+		// The deletion fails, and so does reverting the update.
+		return r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/1002") ||
+			r.Method == http.MethodPut && rec.TTL == 600
+	}))
+	tx := p.Begin("example.com.")
+	txChanges(tx)
+
+	_, err := tx.Commit(context.Background())
+	var terr *TransactionError
+	if !errors.As(err, &terr) {
+		t.Fatalf("error %v, want a *TransactionError", err)
+	}
+	if terr.Undone != 1 || terr.RollbackErr == nil {
+		t.Fatalf("undone %d (%v), want only the creation undone", terr.Undone, terr.RollbackErr)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "; rollback failed after undoing 1 changes, the zone is left partially changed: www A") {
+		t.Errorf("message %q", msg)
+	}
+	want := []string{"@ MX mail.example.com 600", "old A 2.2.2.2 600", "www A 1.1.1.1 300"}
+	if got := zoneContents(api); !slices.Equal(got, want) {
+		t.Errorf("zone %v, want %v", got, want)
+	}
+}
+
+func TestTransactionDryRun(t *testing.T) {
+	api := txZone()
+	p := testProvider(t, api)
+	p.DryRun = true
+	tx := p.Begin("example.com.")
+	txChanges(tx)
+
+	var plan Plan
+	if _, err := tx.Commit(WithPlan(context.Background(), &plan)); err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, op := range plan.Operations() {
+		actions = append(actions, op.Action+" "+op.Record.Name)
+	}
+	if want := []string{"create _acme-challenge", "update www", "delete old"}; !slices.Equal(actions, want) {
+		t.Errorf("plan %v, want %v", actions, want)
+	}
+	if reqs := api.Requests(); len(reqs) != 0 {
+		t.Errorf("dry run sent %v", reqs)
+	}
+}
+
+// singleCNAME wraps api to refuse a CNAME next to other records of its
+// name, like the API does.
+func singleCNAME(api *fakeapi.API) http.Handler {
+	return failing(api, func(r *http.Request, rec fakeapi.Record) bool {
+		if r.Method != http.MethodPost {
+			return false
+		}
+		for _, cur := range api.Records() {
+			if strings.EqualFold(cur.Name, rec.Name) && (cur.Type == "CNAME" || rec.Type == "CNAME") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestSetRecordsReplacesCNAME(t *testing.T) {
+	api := fakeapi.New(fakeapi.Record{Name: "www", Type: "CNAME", Content: "a.example.com", TTL: 600})
+	p := testProvider(t, singleCNAME(api))
+
+	set, err := p.SetRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.CNAME{Name: "www", Target: "b.example.com", TTL: 600 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 1 || RecordID(set[0]) != "1001" {
+		t.Errorf("set %v, want the CNAME with ID 1001", set)
+	}
+	if got := zoneContents(api); !slices.Equal(got, []string{"www CNAME b.example.com 600"}) {
+		t.Errorf("zone %v", got)
+	}
+}
+
+func TestSetRecordsReplacesRRset(t *testing.T) {
+	api := fakeapi.New(
+		fakeapi.Record{Name: "www", Type: "A", Content: "1.1.1.1", TTL: 600},
+		fakeapi.Record{Name: "www", Type: "A", Content: "2.2.2.2", TTL: 600},
+		fakeapi.Record{Name: "www", Type: "AAAA", Content: "::1", TTL: 600},
+	)
+	p := testProvider(t, api)
+
+	set, err := p.SetRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.Address{Name: "www", IP: netip.MustParseAddr("2.2.2.2"), TTL: 600 * time.Second},
+		libdns.Address{Name: "www", IP: netip.MustParseAddr("3.3.3.3"), TTL: 600 * time.Second},
+		libdns.Address{Name: "www", IP: netip.MustParseAddr("4.4.4.4"), TTL: 600 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 3 || RecordID(set[1]) == "" || RecordID(set[2]) == "" {
+		t.Errorf("set %v, want the created records with IDs", set)
+	}
+	want := []string{"www A 2.2.2.2 600", "www A 3.3.3.3 600", "www A 4.4.4.4 600", "www AAAA ::1 600"}
+	if got := zoneContents(api); !slices.Equal(got, want) {
+		t.Errorf("zone %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+// Package zone provides zone-level tooling on top of libdns record sets,
+// such as comparing two sets of records and loading them from zone files
+// or JSON/YAML dumps.
+package zone
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// ChangeKind describes how a record differs between two record sets.
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "add"
+	Removed  ChangeKind = "remove"
+	Modified ChangeKind = "modify"
+)
+
+// Change is a single difference between two record sets. Name, Type and
+// Data are in normalised form (see Normalize). For Added records OldTTL is
+// zero, for Removed records NewTTL is zero.
+type Change struct {
+	Kind   ChangeKind
+	Name   string
+	Type   string
+	Data   string
+	OldTTL time.Duration
+	NewTTL time.Duration
+}
+
+// String formats the change as a single diff line.
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s %d %s %s", c.Name, int(c.NewTTL.Seconds()), c.Type, c.Data)
+	case Removed:
+		return fmt.Sprintf("- %s %d %s %s", c.Name, int(c.OldTTL.Seconds()), c.Type, c.Data)
+	default:
+		return fmt.Sprintf("~ %s %s %s (ttl %d -> %d)", c.Name, c.Type, c.Data,
+			int(c.OldTTL.Seconds()), int(c.NewTTL.Seconds()))
+	}
+}
+
+// DiffOptions controls how record sets are normalised before comparison.
+type DiffOptions struct {
+	// FromZone and ToZone are the zones the respective record sets belong
+	// to. Names are made relative to them, so records of two different
+	// domains (e.g. staging and production) can be compared.
+	FromZone string
+	ToZone   string
+
+	// IgnoreTTL reports records that only differ in TTL as equal.
+	IgnoreTTL bool
+
+	// DefaultTTL is assumed for records without a TTL.
+	DefaultTTL time.Duration
+}
+
+// Diff compares two record sets and returns the changes needed to turn
+// from into to, sorted by name, type and data. Records are compared
+// semantically: names are made relative to their zone and lowercased, TTLs
+// are rounded to whole seconds, and TXT data is unquoted, so that the same
+// zone read from the API, a zone file or a dump compares as equal.
+func Diff(from, to []libdns.Record, opts DiffOptions) []Change {
+	a := normalizeSet(from, opts.FromZone, opts.DefaultTTL)
+	b := normalizeSet(to, opts.ToZone, opts.DefaultTTL)
+
+	var changes []Change
+	for key, old := range a {
+		cur, ok := b[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: Removed, Name: old.Name, Type: old.Type, Data: old.Data, OldTTL: old.TTL})
+		case old.TTL != cur.TTL && !opts.IgnoreTTL:
+			changes = append(changes, Change{Kind: Modified, Name: old.Name, Type: old.Type, Data: old.Data, OldTTL: old.TTL, NewTTL: cur.TTL})
+		}
+	}
+	for key, cur := range b {
+		if _, ok := a[key]; !ok {
+			changes = append(changes, Change{Kind: Added, Name: cur.Name, Type: cur.Type, Data: cur.Data, NewTTL: cur.TTL})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		x, y := changes[i], changes[j]
+		if x.Name != y.Name {
+			return x.Name < y.Name
+		}
+		if x.Type != y.Type {
+			return x.Type < y.Type
+		}
+		if x.Data != y.Data {
+			return x.Data < y.Data
+		}
+		return x.Kind < y.Kind
+	})
+	return changes
+}
+
+// normalizeSet normalises recs and indexes them by name, type and data.
+// Duplicate records collapse into one, as DNS treats RRsets as sets.
+func normalizeSet(recs []libdns.Record, zone string, defaultTTL time.Duration) map[string]libdns.RR {
+	set := make(map[string]libdns.RR, len(recs))
+	for _, rec := range recs {
+		rr := Normalize(rec.RR(), zone)
+		if rr.TTL == 0 {
+			rr.TTL = defaultTTL
+		}
+		set[rr.Name+" "+rr.Type+" "+rr.Data] = rr
+	}
+	return set
+}
+
+// Normalize returns rr in the canonical form used by Diff: the name relative
+// to zone ("@" for the apex) and lowercased, the type uppercased, the TTL
+// rounded to seconds and the data normalised for its type.
+func Normalize(rr libdns.RR, zone string) libdns.RR {
+	rr.Type = strings.ToUpper(rr.Type)
+	rr.Name = normalizeName(rr.Name, zone)
+	rr.TTL = rr.TTL.Round(time.Second)
+	rr.Data = normalizeData(rr.Type, rr.Data)
+	return rr
+}
+
+func normalizeName(name, zone string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	switch {
+	case name == "" || name == "@" || (zone != "" && name == zone):
+		return "@"
+	case zone != "" && strings.HasSuffix(name, "."+zone):
+		return strings.TrimSuffix(name, "."+zone)
+	}
+	return name
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func normalizeData(typ, data string) string {
+	data = strings.TrimSpace(data)
+	switch typ {
+	case "TXT", "SPF":
+		return unquoteTXT(data)
+	case "CNAME", "NS", "PTR", "DNAME":
+		return normalizeHost(data)
+	case "MX":
+		if f := strings.Fields(data); len(f) == 2 {
+			return f[0] + " " + normalizeHost(f[1])
+		}
+	case "SRV":
+		if f := strings.Fields(data); len(f) == 4 {
+			return strings.Join(f[:3], " ") + " " + normalizeHost(f[3])
+		}
+	}
+	return strings.Join(strings.Fields(data), " ")
+}
+
+// unquoteTXT turns TXT data in zone file presentation format, i.e. one or
+// more quoted character strings, into a single unquoted string. Data which
+// is not quoted is returned unchanged.
+func unquoteTXT(data string) string {
+	if !strings.HasPrefix(data, `"`) {
+		return data
+	}
+	var b strings.Builder
+	for rest := data; rest != ""; rest = strings.TrimLeft(rest, " \t") {
+		if rest[0] != '"' {
+			return data
+		}
+		end := 1
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return data
+		}
+		s, err := unescapeTXT(rest[1:end])
+		if err != nil {
+			return data
+		}
+		b.WriteString(s)
+		rest = rest[end+1:]
+	}
+	return b.String()
+}
+
+// unescapeTXT resolves \X and \DDD escapes of a character string.
+func unescapeTXT(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+			n, err := strconv.Atoi(s[i+1 : i+4])
+			if err != nil || n > 255 {
+				return "", fmt.Errorf("invalid escape \\%s", s[i+1:i+4])
+			}
+			b.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+		if i+1 < len(s) {
+			b.WriteByte(s[i+1])
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
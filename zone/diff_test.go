@@ -0,0 +1,180 @@
+package zone
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/libdns/websupport/websupport"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   libdns.RR
+		zone string
+		want libdns.RR
+	}{
+		{"apex by zone name", libdns.RR{Name: "Example.COM.", Type: "a", Data: "192.0.2.1"}, "example.com.",
+			libdns.RR{Name: "@", Type: "A", Data: "192.0.2.1"}},
+		{"empty name", libdns.RR{Name: "", Type: "A", Data: "192.0.2.1"}, "example.com",
+			libdns.RR{Name: "@", Type: "A", Data: "192.0.2.1"}},
+		{"absolute name", libdns.RR{Name: "WWW.example.com.", Type: "A", Data: "192.0.2.1"}, "example.com",
+			libdns.RR{Name: "www", Type: "A", Data: "192.0.2.1"}},
+		{"name outside the zone", libdns.RR{Name: "www.example.org.", Type: "A", Data: "192.0.2.1"}, "example.com",
+			libdns.RR{Name: "www.example.org", Type: "A", Data: "192.0.2.1"}},
+		{"TTL rounded", libdns.RR{Name: "www", Type: "A", TTL: 600*time.Second + 400*time.Millisecond, Data: "192.0.2.1"}, "example.com",
+			libdns.RR{Name: "www", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"}},
+		{"CNAME trailing dot and case", libdns.RR{Name: "blog", Type: "CNAME", Data: "Pages.Example.NET."}, "example.com",
+			libdns.RR{Name: "blog", Type: "CNAME", Data: "pages.example.net"}},
+		{"MX target", libdns.RR{Name: "@", Type: "MX", Data: "10  Mail.example.com."}, "example.com",
+			libdns.RR{Name: "@", Type: "MX", Data: "10 mail.example.com"}},
+		{"SRV target", libdns.RR{Name: "_sip._tcp", Type: "SRV", Data: "10 5 5060 SIP.example.com."}, "example.com",
+			libdns.RR{Name: "_sip._tcp", Type: "SRV", Data: "10 5 5060 sip.example.com"}},
+		{"TXT quoted", libdns.RR{Name: "@", Type: "TXT", Data: `"v=spf1 -all"`}, "example.com",
+			libdns.RR{Name: "@", Type: "TXT", Data: "v=spf1 -all"}},
+		{"TXT split strings", libdns.RR{Name: "@", Type: "TXT", Data: `"abc" "def"`}, "example.com",
+			libdns.RR{Name: "@", Type: "TXT", Data: "abcdef"}},
+		{"TXT escapes", libdns.RR{Name: "@", Type: "TXT", Data: `"a\"b\059c"`}, "example.com",
+			libdns.RR{Name: "@", Type: "TXT", Data: `a"b;c`}},
+		{"TXT unquoted keeps case", libdns.RR{Name: "@", Type: "TXT", Data: "Token=AbC"}, "example.com",
+			libdns.RR{Name: "@", Type: "TXT", Data: "Token=AbC"}},
+		{"TXT unterminated quote", libdns.RR{Name: "@", Type: "TXT", Data: `"abc`}, "example.com",
+			libdns.RR{Name: "@", Type: "TXT", Data: `"abc`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.in, tt.zone); got != tt.want {
+				t.Errorf("Normalize(%+v, %q) = %+v, want %+v", tt.in, tt.zone, got, tt.want)
+			}
+		})
+	}
+}
+
+// The same zone as the Websupport provider returns it, and as zone file,
+// JSON dump and YAML record list.
+var (
+	liveZone = []libdns.Record{
+		websupport.WithRecordID(libdns.Address{Name: "www", TTL: 600 * time.Second, IP: netip.MustParseAddr("192.0.2.1")}, "1001"),
+		websupport.WithRecordID(libdns.CNAME{Name: "blog", TTL: 600 * time.Second, Target: "pages.example.net"}, "1002"),
+		websupport.WithRecordID(libdns.MX{Name: "@", TTL: 3600 * time.Second, Preference: 10, Target: "mail.example.com"}, "1003"),
+		websupport.WithRecordID(libdns.TXT{Name: "@", TTL: 600 * time.Second, Text: "v=spf1 include:_spf.example.com ~all"}, "1004"),
+	}
+	zoneFile = `$ORIGIN example.com.
+$TTL 600
+@    3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 900 1209600 600
+WWW       IN A     192.0.2.1
+blog      IN CNAME Pages.Example.NET.
+@    3600 IN MX    10 mail.example.com.
+@         IN TXT   "v=spf1 " "include:_spf.example.com ~all"
+`
+	jsonDump = `{"zone": "example.com.", "records": [
+	{"id": "1001", "name": "www.example.com.", "type": "A", "ttl": 600, "data": "192.0.2.1"},
+	{"name": "blog", "type": "cname", "ttl": 600, "data": "pages.example.net."},
+	{"name": "@", "type": "MX", "ttl": 3600, "data": "10 mail.example.com"},
+	{"name": "", "type": "TXT", "ttl": 600, "data": "\"v=spf1 include:_spf.example.com ~all\""}
+]}`
+	yamlList = `
+- {name: www, type: A, ttl: 600, data: 192.0.2.1}
+- {name: blog, type: CNAME, ttl: 600, data: pages.example.net}
+- {name: "@", type: MX, ttl: 3600, data: 10 mail.example.com.}
+- {name: "@", type: TXT, ttl: 600, data: "v=spf1 include:_spf.example.com ~all"}
+`
+)
+
+func TestDiffAcrossFormats(t *testing.T) {
+	fromFile, err := ParseZoneFile(strings.NewReader(zoneFile), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := ParseJSON(strings.NewReader(jsonDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := ParseYAML(strings.NewReader(yamlList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := map[string][]libdns.Record{"live": liveZone, "zone file": fromFile, "JSON": fromJSON, "YAML": fromYAML}
+
+	for fromName, from := range sets {
+		for toName, to := range sets {
+			opts := DiffOptions{FromZone: "example.com.", ToZone: "example.com"}
+			if changes := Diff(from, to, opts); len(changes) != 0 {
+				t.Errorf("%s -> %s: got changes %v, want none", fromName, toName, changes)
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		from, to []libdns.Record
+		opts     DiffOptions
+		want     []string
+	}{
+		{
+			name: "TTL change",
+			from: []libdns.Record{libdns.RR{Name: "www", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"}},
+			to:   []libdns.Record{libdns.RR{Name: "www", Type: "A", TTL: 300 * time.Second, Data: "192.0.2.1"}},
+			want: []string{"~ www A 192.0.2.1 (ttl 600 -> 300)"},
+		},
+		{
+			name: "TTL change ignored",
+			from: []libdns.Record{libdns.RR{Name: "www", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"}},
+			to:   []libdns.Record{libdns.RR{Name: "www", Type: "A", TTL: 300 * time.Second, Data: "192.0.2.1"}},
+			opts: DiffOptions{IgnoreTTL: true},
+		},
+		{
+			name: "default TTL",
+			from: []libdns.Record{libdns.RR{Name: "www", Type: "A", Data: "192.0.2.1"}},
+			to:   []libdns.Record{libdns.RR{Name: "www", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"}},
+			opts: DiffOptions{DefaultTTL: 600 * time.Second},
+		},
+		{
+			name: "staging and production",
+			from: []libdns.Record{
+				libdns.RR{Name: "WWW.staging.example.com.", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"},
+				libdns.RR{Name: "api.staging.example.com.", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.2"},
+			},
+			to: []libdns.Record{
+				libdns.RR{Name: "www", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"},
+				libdns.RR{Name: "api", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.3"},
+			},
+			opts: DiffOptions{FromZone: "staging.example.com.", ToZone: "example.com"},
+			want: []string{
+				"- api 600 A 192.0.2.2",
+				"+ api 600 A 192.0.2.3",
+			},
+		},
+		{
+			name: "TXT content",
+			from: []libdns.Record{libdns.RR{Name: "@", Type: "TXT", TTL: 600 * time.Second, Data: `"v=spf1 -all"`}},
+			to:   []libdns.Record{libdns.TXT{Name: "@", TTL: 600 * time.Second, Text: "v=spf1 ~all"}},
+			want: []string{
+				"- @ 600 TXT v=spf1 -all",
+				"+ @ 600 TXT v=spf1 ~all",
+			},
+		},
+		{
+			name: "duplicates collapse",
+			from: []libdns.Record{
+				libdns.RR{Name: "www", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"},
+				libdns.RR{Name: "www.", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"},
+			},
+			to: []libdns.Record{libdns.RR{Name: "www", Type: "A", TTL: 600 * time.Second, Data: "192.0.2.1"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range Diff(tt.from, tt.to, tt.opts) {
+				got = append(got, c.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package zone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/libdns/websupport/websupport"
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// Record is the serialised form of a DNS record in JSON dumps and YAML
+// record lists. TTL is in seconds.
+type Record struct {
+	ID   string `json:"id,omitempty" yaml:"id,omitempty"`
+	Name string `json:"name" yaml:"name"`
+	Type string `json:"type" yaml:"type"`
+	TTL  int    `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	Data string `json:"data" yaml:"data"`
+}
+
+// NewRecord converts a libdns record into its serialised form. A string
+// ProviderData (as set by the Websupport provider) is kept as the ID.
+func NewRecord(rec libdns.Record) Record {
+	rr := rec.RR()
+	return Record{
+		ID:   websupport.RecordID(rec),
+		Name: rr.Name,
+		Type: rr.Type,
+		TTL:  int(rr.TTL.Seconds()),
+		Data: rr.Data,
+	}
+}
+
+// RR returns the record in libdns' generic representation.
+func (r Record) RR() libdns.RR {
+	return libdns.RR{
+		Name: r.Name,
+		Type: strings.ToUpper(r.Type),
+		TTL:  time.Duration(r.TTL) * time.Second,
+		Data: r.Data,
+	}
+}
+
+// ReadFile loads a record set from path. The format is chosen by extension:
+// ".json" for JSON dumps, ".yaml" or ".yml" for YAML record lists, and zone
+// file syntax for anything else. origin is used to resolve relative names
+// in zone files.
+func ReadFile(path, origin string) ([]libdns.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseJSON(f)
+	case ".yaml", ".yml":
+		return ParseYAML(f)
+	}
+	return ParseZoneFile(f, origin)
+}
+
+// ParseZoneFile reads records in RFC 1035 zone file syntax. Names are
+// returned relative to origin. SOA records are skipped, as they are managed
+// by the DNS provider rather than the zone owner.
+func ParseZoneFile(r io.Reader, origin string) ([]libdns.Record, error) {
+	origin = dns.Fqdn(origin)
+	zp := dns.NewZoneParser(r, origin, "")
+
+	var recs []libdns.Record
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeSOA {
+			continue
+		}
+		recs = append(recs, libdns.RR{
+			Name: libdns.RelativeName(hdr.Name, origin),
+			Type: dns.TypeToString[hdr.Rrtype],
+			TTL:  time.Duration(hdr.Ttl) * time.Second,
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse zone file: %v", err)
+	}
+	return recs, nil
+}
+
+// ParseJSON reads a JSON dump: either a list of records or an object with
+// a "records" list, such as a snapshot.
+func ParseJSON(r io.Reader) ([]libdns.Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var list []Record
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var wrapper struct {
+			Records []Record `json:"records"`
+		}
+		err = json.Unmarshal(data, &wrapper)
+		list = wrapper.Records
+	} else {
+		err = json.Unmarshal(data, &list)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON records: %v", err)
+	}
+	return toLibdns(list), nil
+}
+
+// ParseYAML reads a YAML list of records.
+func ParseYAML(r io.Reader) ([]libdns.Record, error) {
+	var list []Record
+	if err := yaml.NewDecoder(r).Decode(&list); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to decode YAML records: %v", err)
+	}
+	return toLibdns(list), nil
+}
+
+func toLibdns(list []Record) []libdns.Record {
+	recs := make([]libdns.Record, 0, len(list))
+	for _, r := range list {
+		recs = append(recs, r.RR())
+	}
+	return recs
+}
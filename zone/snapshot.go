@@ -0,0 +1,261 @@
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/libdns/websupport/websupport"
+)
+
+// snapshotTimeFormat is used in snapshot file names. It has nanoseconds,
+// so that snapshots taken in the same second don't overwrite each other,
+// and contains no characters that are invalid in paths.
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+// snapshotTimeLayout parses the times of snapshot file names, with or
+// without the fraction (names of earlier versions have none).
+const snapshotTimeLayout = "20060102T150405Z"
+
+// Snapshot is the complete record set of a zone at a point in time,
+// including the Websupport record IDs.
+type Snapshot struct {
+	Zone      string    `json:"zone"`
+	ServiceID string    `json:"service_id"`
+	TakenAt   time.Time `json:"taken_at"`
+	Records   []Record  `json:"records"`
+}
+
+// TakeSnapshot reads all records of zone from p.
+func TakeSnapshot(ctx context.Context, p *websupport.Provider, zone string) (*Snapshot, error) {
+	recs, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{
+		Zone:      zone,
+		ServiceID: p.ServiceID,
+		TakenAt:   time.Now().UTC(),
+		Records:   make([]Record, 0, len(recs)),
+	}
+	for _, rec := range recs {
+		s.Records = append(s.Records, NewRecord(rec))
+	}
+	return s, nil
+}
+
+// ReadSnapshot loads a snapshot written by WriteFile.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// WriteFile writes the snapshot as indented JSON. The file is only readable
+// by the owner, as it describes the whole zone.
+func (s *Snapshot) WriteFile(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// History stores timestamped snapshots in a local directory and keeps only
+// the most recent ones of each zone.
+type History struct {
+	Dir string
+
+	// Keep is the number of snapshots kept per zone. Zero or less keeps
+	// all of them.
+	Keep int
+}
+
+// Save writes s to the history directory as <zone>-<timestamp>.json and
+// removes snapshots of the same zone beyond Keep. It returns the path of
+// the new snapshot.
+func (h History) Save(s *Snapshot) (string, error) {
+	if err := os.MkdirAll(h.Dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(h.Dir, fmt.Sprintf("%s-%s.json", strings.TrimSuffix(s.Zone, "."), s.TakenAt.UTC().Format(snapshotTimeFormat)))
+	if err := s.WriteFile(path); err != nil {
+		return "", err
+	}
+
+	if h.Keep > 0 {
+		paths, err := h.List(s.Zone)
+		if err != nil {
+			return path, err
+		}
+		for len(paths) > h.Keep {
+			if err := os.Remove(paths[0]); err != nil {
+				return path, err
+			}
+			paths = paths[1:]
+		}
+	}
+	return path, nil
+}
+
+// List returns the snapshot files of zone, oldest first. Files of other
+// zones whose names start with the same characters, like a.com-b.sk for
+// a.com, are not included.
+func (h History) List(zone string) ([]string, error) {
+	entries, err := os.ReadDir(h.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	type snapshotFile struct {
+		path    string
+		takenAt time.Time
+	}
+	var files []snapshotFile
+	for _, e := range entries {
+		name, takenAt, ok := parseSnapshotName(e.Name())
+		if ok && !e.IsDir() && strings.EqualFold(name, strings.TrimSuffix(zone, ".")) {
+			files = append(files, snapshotFile{filepath.Join(h.Dir, e.Name()), takenAt})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].takenAt.Before(files[j].takenAt) })
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// parseSnapshotName splits a snapshot file name, <zone>-<timestamp>.json,
+// into the zone and the time. Zone names may contain dashes, timestamps
+// don't.
+func parseSnapshotName(file string) (zone string, takenAt time.Time, ok bool) {
+	base, ok := strings.CutSuffix(file, ".json")
+	i := strings.LastIndex(base, "-")
+	if !ok || i <= 0 {
+		return "", time.Time{}, false
+	}
+	takenAt, err := time.Parse(snapshotTimeLayout, base[i+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return base[:i], takenAt, true
+}
+
+// RestoreResult lists the changes Restore made to the zone.
+type RestoreResult struct {
+	Created []libdns.Record
+	Updated []libdns.Record
+	Deleted []libdns.Record
+}
+
+// Restore brings the zone back to the state of s: records deleted since the
+// snapshot are recreated (with new IDs), modified records are reverted and
+// records added since are deleted. On error, the result lists the changes
+// made so far.
+func Restore(ctx context.Context, p *websupport.Provider, s *Snapshot) (*RestoreResult, error) {
+	current, err := p.GetRecords(ctx, s.Zone)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]int)
+	for i, rec := range current {
+		if id := websupport.RecordID(rec); id != "" {
+			byID[id] = i
+		}
+	}
+	claimed := make([]bool, len(current))
+
+	var create, update []libdns.Record
+	var unmatched []Record
+
+	// Records which still exist under the same ID are reverted in place.
+	for _, want := range s.Records {
+		i, ok := byID[want.ID]
+		if want.ID == "" || !ok {
+			unmatched = append(unmatched, want)
+			continue
+		}
+		claimed[i] = true
+		if Normalize(current[i].RR(), s.Zone) != Normalize(want.RR(), s.Zone) {
+			update = append(update, withID(want.RR(), want.ID))
+		}
+	}
+
+	// The others may exist with identical content under a different ID,
+	// e.g. after an earlier restore; only the truly missing ones are created.
+	for _, want := range unmatched {
+		w := Normalize(want.RR(), s.Zone)
+		found := false
+		for i, cur := range current {
+			c := Normalize(cur.RR(), s.Zone)
+			if claimed[i] || c.Name != w.Name || c.Type != w.Type || c.Data != w.Data {
+				continue
+			}
+			claimed[i] = true
+			if c.TTL != w.TTL {
+				update = append(update, withID(want.RR(), websupport.RecordID(cur)))
+			}
+			found = true
+			break
+		}
+		if !found {
+			create = append(create, want.RR())
+		}
+	}
+
+	var del []libdns.Record
+	for i, cur := range current {
+		if !claimed[i] {
+			del = append(del, cur)
+		}
+	}
+
+	result := &RestoreResult{}
+	if len(create) > 0 {
+		created, err := p.AppendRecords(ctx, s.Zone, create)
+		result.Created = created
+		if err != nil {
+			return result, fmt.Errorf("failed to recreate records: %v", err)
+		}
+	}
+	for _, rec := range update {
+		if _, err := p.UpdateRecord(ctx, s.Zone, rec); err != nil {
+			return result, fmt.Errorf("failed to revert record %s %s: %v", rec.RR().Name, rec.RR().Type, err)
+		}
+		result.Updated = append(result.Updated, rec)
+	}
+	if len(del) > 0 {
+		deleted, err := p.DeleteRecords(ctx, s.Zone, del)
+		result.Deleted = deleted
+		if err != nil {
+			return result, fmt.Errorf("failed to delete records: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// withID returns rr as a record that carries the Websupport ID id.
+func withID(rr libdns.RR, id string) libdns.Record {
+	rec, err := rr.Parse()
+	if err != nil {
+		return rr
+	}
+	return websupport.WithRecordID(rec, id)
+}
@@ -0,0 +1,73 @@
+package zone
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHistoryList(t *testing.T) {
+	h := History{Dir: t.TempDir(), Keep: 2}
+	taken := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// An earlier version's name without the fraction, and a file of a
+	// zone whose name starts like a.com's.
+	os.WriteFile(filepath.Join(h.Dir, "a.com-20261018T115959Z.json"), []byte("{}"), 0600)
+	other, err := h.Save(&Snapshot{Zone: "a.com-b.sk.", TakenAt: taken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots within the same second are kept apart.
+	var saved []string
+	for i := range 3 {
+		path, err := h.Save(&Snapshot{Zone: "a.com.", TakenAt: taken.Add(time.Duration(i) * time.Millisecond)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, path)
+	}
+
+	paths, err := h.List("a.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(paths, saved[1:]) {
+		t.Errorf("a.com snapshots %v, want the newest two: %v", paths, saved[1:])
+	}
+	if paths, _ := h.List("a.com-b.sk."); !slices.Equal(paths, []string{other}) {
+		t.Errorf("a.com-b.sk snapshots %v, want %v", paths, []string{other})
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("pruning a.com removed a snapshot of a.com-b.sk: %v", err)
+	}
+}
+
+func TestHistoryListMissingDir(t *testing.T) {
+	paths, err := History{Dir: filepath.Join(t.TempDir(), "missing")}.List("a.com.")
+	if err != nil || paths != nil {
+		t.Errorf("List = %v, %v; want nothing", paths, err)
+	}
+}
+
+func TestParseSnapshotName(t *testing.T) {
+	for _, tt := range []struct {
+		file string
+		zone string
+		ok   bool
+	}{
+		{"example.com-20261018T120000.123456789Z.json", "example.com", true},
+		{"example.com-20261018T120000Z.json", "example.com", true},
+		{"my-zone.sk-20261018T120000Z.json", "my-zone.sk", true},
+		{"example.com-latest.json", "", false},
+		{"example.com-20261018T120000Z.yaml", "", false},
+		{"-20261018T120000Z.json", "", false},
+	} {
+		zone, _, ok := parseSnapshotName(tt.file)
+		if zone != tt.zone || ok != tt.ok {
+			t.Errorf("parseSnapshotName(%q) = %q, %v; want %q, %v", tt.file, zone, ok, tt.zone, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/websupport/websupport"
+	"github.com/libdns/websupport/zone"
+)
+
+// runZone dispatches the "zone" subcommands.
+func runZone(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: libdns-websupport zone <diff|snapshot|restore> [args]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "diff":
+		zoneDiff(args[1:])
+	case "snapshot":
+		zoneSnapshot(args[1:])
+	case "restore":
+		zoneRestore(args[1:])
+	default:
+		fmt.Printf("Unknown zone command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// zoneDiff prints the semantic difference between two record sets. Exits
+// with status 2 if they differ, like diff(1).
+func zoneDiff(args []string) {
+	fs := flag.NewFlagSet("zone diff", flag.ExitOnError)
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "zone both record sets belong to")
+	fromZone := fs.String("from-zone", "", "zone of the first record set (default: -zone)")
+	toZone := fs.String("to-zone", "", "zone of the second record set (default: -zone)")
+	ignoreTTL := fs.Bool("ignore-ttl", false, "ignore TTL differences")
+	defaultTTL := fs.Duration("default-ttl", 600*time.Second, "TTL assumed for records without one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: libdns-websupport zone diff [flags] FROM TO")
+		fmt.Fprintln(fs.Output(), "")
+		fmt.Fprintln(fs.Output(), "FROM and TO are either \"websupport\" (the live zone of WEBSUPPORT_SERVICE_ID),")
+		fmt.Fprintln(fs.Output(), "\"websupport:SERVICE_ID\", or a zone file, .json dump or .yaml record list.")
+		fmt.Fprintln(fs.Output(), "")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *fromZone == "" {
+		*fromZone = *zoneName
+	}
+	if *toZone == "" {
+		*toZone = *zoneName
+	}
+
+	ctx := context.Background()
+	from, err := loadRecordSource(ctx, fs.Arg(0), *fromZone)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", fs.Arg(0), err)
+	}
+	to, err := loadRecordSource(ctx, fs.Arg(1), *toZone)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", fs.Arg(1), err)
+	}
+
+	changes := zone.Diff(from, to, zone.DiffOptions{
+		FromZone:   *fromZone,
+		ToZone:     *toZone,
+		IgnoreTTL:  *ignoreTTL,
+		DefaultTTL: *defaultTTL,
+	})
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) > 0 {
+		os.Exit(2)
+	}
+}
+
+// loadRecordSource reads the records of a live Websupport zone or a file.
+func loadRecordSource(ctx context.Context, source, zoneName string) ([]libdns.Record, error) {
+	if source == "websupport" || strings.HasPrefix(source, "websupport:") {
+		provider := newProviderFromEnv(strings.TrimPrefix(strings.TrimPrefix(source, "websupport"), ":"))
+		return provider.GetRecords(ctx, zoneName)
+	}
+	return zone.ReadFile(source, zoneName)
+}
+
+// defaultSnapshotDir returns the directory snapshots are kept in unless
+// WEBSUPPORT_SNAPSHOT_DIR or -dir says otherwise.
+func defaultSnapshotDir() string {
+	if dir := os.Getenv("WEBSUPPORT_SNAPSHOT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(stateDir(), "snapshots")
+}
+
+// stateDir is the directory the CLI keeps local state in.
+func stateDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil || homeDir == "" {
+		homeDir = "." // fallback to current directory if home cannot be resolved
+	}
+	return filepath.Join(homeDir, ".libdns-websupport")
+}
+
+// zoneSnapshot saves the full record set of the live zone, including record
+// IDs, to a timestamped JSON file.
+func zoneSnapshot(args []string) {
+	fs := flag.NewFlagSet("zone snapshot", flag.ExitOnError)
+	zoneName := fs.String("zone", os.Getenv("WEBSUPPORT_TEST_ZONE"), "zone to snapshot")
+	dir := fs.String("dir", defaultSnapshotDir(), "directory to keep snapshots in")
+	keep := fs.Int("keep", 20, "number of snapshots to keep per zone (0 keeps all)")
+	fs.Parse(args)
+	if *zoneName == "" {
+		log.Fatal("Error: -zone or WEBSUPPORT_TEST_ZONE must be set")
+	}
+
+	provider := newProviderFromEnv("")
+	snapshot, err := zone.TakeSnapshot(context.Background(), provider, *zoneName)
+	if err != nil {
+		log.Fatalf("❌ Failed to read zone: %v", err)
+	}
+	path, err := zone.History{Dir: *dir, Keep: *keep}.Save(snapshot)
+	if err != nil {
+		log.Fatalf("❌ Failed to save snapshot: %v", err)
+	}
+	log.Printf("✅ Saved %d records to %s\n", len(snapshot.Records), path)
+}
+
+// zoneRestore brings the live zone back to the state of a snapshot.
+func zoneRestore(args []string) {
+	fs := flag.NewFlagSet("zone restore", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the planned changes as JSON instead of applying them")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: libdns-websupport zone restore [-dry-run] SNAPSHOT")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	snapshot, err := zone.ReadSnapshot(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("❌ Failed to read snapshot: %v", err)
+	}
+	provider := newProviderFromEnv(snapshot.ServiceID)
+	provider.DryRun = *dryRun
+
+	log.Printf("⏪ Restoring %s to %s\n", snapshot.Zone, snapshot.TakenAt.Format(time.RFC3339))
+	var plan websupport.Plan
+	ctx := websupport.WithPlan(context.Background(), &plan)
+	result, err := zone.Restore(ctx, provider, snapshot)
+	if result != nil {
+		for _, rec := range result.Created {
+			log.Printf("   + %s %s %s\n", rec.RR().Name, rec.RR().Type, rec.RR().Data)
+		}
+		for _, rec := range result.Updated {
+			log.Printf("   ~ %s %s %s\n", rec.RR().Name, rec.RR().Type, rec.RR().Data)
+		}
+		for _, rec := range result.Deleted {
+			log.Printf("   - %s %s %s\n", rec.RR().Name, rec.RR().Type, rec.RR().Data)
+		}
+	}
+	if err != nil {
+		log.Fatalf("❌ Restore failed: %v", err)
+	}
+	if *dryRun {
+		printPlan(plan.Operations())
+		return
+	}
+	log.Printf("✅ Restored (%d created, %d reverted, %d deleted)\n",
+		len(result.Created), len(result.Updated), len(result.Deleted))
+}
+
+// printPlan writes the operations planned in dry-run mode to stdout as JSON.
+func printPlan(plan []websupport.Operation) {
+	if plan == nil {
+		plan = []websupport.Operation{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		log.Fatalf("Failed to encode plan: %v", err)
+	}
+}